@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+const APIRequestTableName = "crc_api_request"
+
+// Service is the service the requests of the table are rate limited and
+// timed out as, since their endpoint is only known at query time
+const Service = "api_request"
+
+// apiRequestRow is the response to a request, or one of the items of its
+// array at items_path
+type apiRequestRow struct {
+	Endpoint    string
+	QueryParams json.RawMessage
+	ItemsPath   string
+	StatusCode  int
+	Headers     map[string]string
+	Response    json.RawMessage
+}
+
+func TableAPIRequest(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:        APIRequestTableName,
+		Description: "Sends a GET request to any endpoint of console.redhat.com with the credentials of the connection, for the endpoints without a table.",
+		Tags:        utils.ServiceTags(Service),
+		List: &plugin.ListConfig{
+			Hydrate: listAPIRequest,
+			KeyColumns: utils.WithCommonKeyColumns(plugin.KeyColumnSlice{
+				{Name: "endpoint", Require: plugin.Required},
+				{Name: "query_params", Require: plugin.Optional},
+				{Name: "items_path", Require: plugin.Optional},
+			}),
+		},
+		Columns: utils.WithCommonColumns([]*plugin.Column{
+			{
+				Name:        "endpoint",
+				Type:        proto.ColumnType_STRING,
+				Description: "The endpoint requested, relative to the base URL, e.g. api/ocp-vulnerability/v1/clusters.",
+				Transform:   transform.FromField("Endpoint"),
+			},
+			{
+				Name:        "query_params",
+				Type:        proto.ColumnType_JSON,
+				Description: "The query parameters of the request, as an object whose values are strings, numbers, booleans or arrays of them for the repeated parameters.",
+				Transform:   transform.FromField("QueryParams"),
+			},
+			{
+				Name:        "items_path",
+				Type:        proto.ColumnType_STRING,
+				Description: "The dot separated path of an array of the response, e.g. data, returned as one row per item.",
+				Transform:   transform.FromField("ItemsPath"),
+			},
+			{
+				Name:        "status_code",
+				Type:        proto.ColumnType_INT,
+				Description: "The status code of the response. The responses with an error status are returned, once the transient errors are retried.",
+				Transform:   transform.FromField("StatusCode"),
+			},
+			{
+				Name:        "headers",
+				Type:        proto.ColumnType_JSON,
+				Description: "The headers of the response, without the cookies.",
+				Transform:   transform.FromField("Headers"),
+			},
+			{
+				Name:        "response",
+				Type:        proto.ColumnType_JSON,
+				Description: "The JSON response, or the item at items_path. A response which isn't JSON is returned as a string.",
+				Transform:   transform.FromField("Response"),
+			},
+		}),
+	}
+}
+
+func listAPIRequest(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	ctx, span := utils.StartHydrateSpan(ctx, d, "listAPIRequest")
+	defer span.End()
+
+	row := apiRequestRow{
+		Endpoint:  d.EqualsQualString("endpoint"),
+		ItemsPath: d.EqualsQualString("items_path"),
+	}
+	if qual, ok := d.EqualsQuals["query_params"]; ok {
+		row.QueryParams = json.RawMessage(qual.GetJsonbValue())
+	}
+	endpoint, err := requestEndpoint(row.Endpoint, row.QueryParams)
+	if err != nil {
+		utils.LogErrorUsingSteampipeLogger(ctx, APIRequestTableName, "query_error", err)
+		return nil, err
+	}
+	if err := utils.ClearDiskCache(ctx, d); err != nil {
+		utils.LogErrorUsingSteampipeLogger(ctx, APIRequestTableName, "query_error", err)
+		return nil, err
+	}
+
+	var body []byte
+	var header http.Header
+	resp, err := utils.MakeAPIRequest(ctx, d, http.MethodGet, endpoint, nil, utils.DefaultTimeout)
+	var apiErr *utils.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		// the error responses are rows too, the body was already read
+		row.StatusCode = apiErr.StatusCode
+		header = apiErr.Header
+		body = []byte(apiErr.Body)
+	case err != nil:
+		utils.LogErrorUsingSteampipeLogger(ctx, APIRequestTableName, "api_error", err)
+		return nil, err
+	default:
+		defer resp.Body.Close()
+		row.StatusCode = resp.StatusCode
+		header = resp.Header
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			utils.LogErrorUsingSteampipeLogger(ctx, APIRequestTableName, "api_error", err)
+			return nil, err
+		}
+	}
+
+	row.Headers = responseHeaders(header)
+	row.Response = jsonResponse(body)
+
+	if row.ItemsPath == "" || row.StatusCode < 200 || row.StatusCode > 299 {
+		utils.StreamListItem(ctx, d, row)
+		return nil, nil
+	}
+
+	items, err := responseItems(row.Response, row.ItemsPath)
+	if err != nil {
+		utils.LogErrorUsingSteampipeLogger(ctx, APIRequestTableName, "decode_error", err)
+		return nil, err
+	}
+	for _, item := range items {
+		itemRow := row
+		itemRow.Response = item
+		utils.StreamListItem(ctx, d, itemRow)
+		if d.RowsRemaining(ctx) == 0 {
+			break
+		}
+	}
+
+	return nil, nil
+}
+
+// requestEndpoint returns the endpoint with the query parameters added to
+// its query string. The endpoint must be relative to the base URL, so that
+// the token of the connection is only sent to console.redhat.com.
+func requestEndpoint(endpoint string, queryParams json.RawMessage) (string, error) {
+	if strings.Contains(endpoint, "://") || strings.HasPrefix(endpoint, "//") {
+		return "", fmt.Errorf("the endpoint must be a path relative to the base URL, e.g. api/ocp-vulnerability/v1/clusters, got %q", endpoint)
+	}
+	u, err := url.Parse(strings.TrimLeft(endpoint, "/"))
+	if err != nil {
+		return "", fmt.Errorf("error parsing endpoint %q: %v", endpoint, err)
+	}
+	if len(queryParams) == 0 {
+		return u.String(), nil
+	}
+
+	var params map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(queryParams))
+	// keep the numbers as they are written, e.g. 1000000 rather than 1e+06
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil || params == nil {
+		return "", fmt.Errorf("the query_params must be a JSON object, got %s", queryParams)
+	}
+	query := u.Query()
+	for name, value := range params {
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		query.Del(name)
+		for _, value := range values {
+			switch value.(type) {
+			case string, json.Number, bool:
+				query.Add(name, fmt.Sprint(value))
+			case nil:
+			default:
+				return "", fmt.Errorf("the query parameter %s must be a string, a number, a boolean or an array of them, got %v", name, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// responseHeaders returns the headers of the response, with the values of
+// the repeated headers joined, and without the sensitive ones
+func responseHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range utils.RedactHeader(header) {
+		if strings.EqualFold(name, "Set-Cookie") {
+			continue
+		}
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+// jsonResponse returns the body if it is JSON, or else the body as a JSON string
+func jsonResponse(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	text, _ := json.Marshal(string(body))
+	return text
+}
+
+// responseItems returns the items of the array at the dot separated path of the response
+func responseItems(response json.RawMessage, itemsPath string) ([]json.RawMessage, error) {
+	value := response
+	path := strings.Split(itemsPath, ".")
+	for i, name := range path {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, fmt.Errorf("the items_path %q of the response doesn't exist, %s isn't an object", itemsPath, describePath(path[:i]))
+		}
+		var ok bool
+		if value, ok = object[name]; !ok {
+			names := make([]string, 0, len(object))
+			for name := range object {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("the items_path %q of the response doesn't exist, %s has no %s field, only %q", itemsPath, describePath(path[:i]), name, names)
+		}
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(value, &items); err != nil {
+		return nil, fmt.Errorf("the items_path %q of the response isn't an array", itemsPath)
+	}
+	return items, nil
+}
+
+// describePath names the value at the path of the response in the errors
+func describePath(path []string) string {
+	if len(path) == 0 {
+		return "the response"
+	}
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    string
+		queryParams string
+		// the expected endpoint, or the expected error if err is set
+		expected string
+		err      string
+	}{
+		{name: "path", endpoint: "/api/ocp-vulnerability/v1/clusters", expected: "api/ocp-vulnerability/v1/clusters"},
+		{
+			name:        "query params",
+			endpoint:    "api/ocp-vulnerability/v1/clusters?sort=id",
+			queryParams: `{"limit": 1000000, "search": "prod eu", "status": ["Ready", "Stale"], "report": true, "ignored": null}`,
+			expected:    "api/ocp-vulnerability/v1/clusters?limit=1000000&report=true&search=prod+eu&sort=id&status=Ready&status=Stale",
+		},
+		{name: "overridden query", endpoint: "api/ocp-vulnerability/v1/clusters?sort=id", queryParams: `{"sort": "-id"}`, expected: "api/ocp-vulnerability/v1/clusters?sort=-id"},
+		{name: "absolute URL", endpoint: "https://example.com/api", err: "relative to the base URL"},
+		{name: "other host", endpoint: "//example.com/api", err: "relative to the base URL"},
+		{name: "not an object", endpoint: "api/ocp-vulnerability/v1/clusters", queryParams: `["limit"]`, err: "must be a JSON object"},
+		{name: "nested object", endpoint: "api/ocp-vulnerability/v1/clusters", queryParams: `{"filter": {"a": 1}}`, err: "query parameter filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queryParams json.RawMessage
+			if tt.queryParams != "" {
+				queryParams = json.RawMessage(tt.queryParams)
+			}
+			endpoint, err := requestEndpoint(tt.endpoint, queryParams)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, endpoint)
+		})
+	}
+}
+
+func TestResponseItems(t *testing.T) {
+	response := json.RawMessage(`{"report": {"data": [{"rule_id": "a"}, "b", null]}, "meta": {}}`)
+
+	items, err := responseItems(response, "report.data")
+	assert.NoError(t, err)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"rule_id": "a"}`), json.RawMessage(`"b"`), json.RawMessage(`null`)}, items)
+
+	_, err = responseItems(response, "data")
+	assert.EqualError(t, err, `the items_path "data" of the response doesn't exist, the response has no data field, only ["meta" "report"]`)
+	_, err = responseItems(response, "report.data.rule_id")
+	assert.ErrorContains(t, err, "report.data isn't an object")
+	_, err = responseItems(response, "meta")
+	assert.ErrorContains(t, err, "isn't an array")
+}
+
+func TestResponseHelpers(t *testing.T) {
+	assert.Equal(t, json.RawMessage(`{"a": 1}`), jsonResponse([]byte(`{"a": 1}`)))
+	assert.Equal(t, json.RawMessage(`"Not Found"`), jsonResponse([]byte(`Not Found`)))
+	assert.Nil(t, jsonResponse(nil))
+
+	headers := responseHeaders(http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Accept", "Origin"},
+		"Set-Cookie":   {"session=secret"},
+	})
+	assert.Equal(t, map[string]string{"content-type": "application/json", "vary": "Accept, Origin"}, headers)
+}
@@ -0,0 +1,191 @@
+package crctest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	grpclib "google.golang.org/grpc"
+)
+
+// ConnectionName is the name of the connection the queries run against
+const ConnectionName = "crctest"
+
+// Row is a row returned by a query, keyed by column name. JSON columns are
+// decoded and timestamps are returned as time.Time.
+type Row map[string]interface{}
+
+// Plugin runs queries against the tables of a plugin, going through the same
+// code paths as a Steampipe query: connection config parsing, rate limiters,
+// List and Get configs, hydrates and column transforms
+type Plugin struct {
+	server *grpc.PluginServer
+	schema map[string]*proto.TableSchema
+}
+
+// NewPlugin creates the plugin and a connection with the given HCL configuration
+func NewPlugin(t *testing.T, pluginFunc plugin.PluginFunc, config string) *Plugin {
+	t.Helper()
+	p, err := LoadPlugin(pluginFunc, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// LoadPlugin is like NewPlugin, but returns the error failing the
+// connection, e.g. an invalid configuration
+func LoadPlugin(pluginFunc plugin.PluginFunc, config string) (*Plugin, error) {
+	server := plugin.Server(&plugin.ServeOpts{PluginFunc: pluginFunc})
+
+	res, err := server.SetAllConnectionConfigs(&proto.SetAllConnectionConfigsRequest{
+		Configs: []*proto.ConnectionConfig{{
+			Connection:      ConnectionName,
+			Plugin:          "juandspy/crc",
+			PluginShortName: "crc",
+			Config:          config,
+		}},
+		MaxCacheSizeMb: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error setting the connection config: %v", err)
+	}
+	if msg, ok := res.FailedConnections[ConnectionName]; ok {
+		return nil, fmt.Errorf("error setting the connection config: %s", msg)
+	}
+
+	schema, err := server.GetSchema(&proto.GetSchemaRequest{Connection: ConnectionName})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the schema: %v", err)
+	}
+
+	return &Plugin{server: server, schema: schema.Schema.Schema}, nil
+}
+
+// Query returns every column of the rows of the table matching the quals.
+// Each qual is an equality on a column, or an IN list if its value is a
+// []string. A map[string]interface{} is compared with a JSON column.
+func (p *Plugin) Query(table string, quals map[string]interface{}) ([]Row, error) {
+	return p.QueryWithLimit(table, quals, -1)
+}
+
+// QueryWithLimit is like Query, with a LIMIT if limit isn't negative
+func (p *Plugin) QueryWithLimit(table string, quals map[string]interface{}, limit int64) ([]Row, error) {
+	tableSchema, ok := p.schema[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown table %s", table)
+	}
+	var columns []string
+	for _, column := range tableSchema.Columns {
+		columns = append(columns, column.Name)
+	}
+
+	protoQuals := map[string]*proto.Quals{}
+	for column, value := range quals {
+		qualValue, err := toQualValue(value)
+		if err != nil {
+			return nil, err
+		}
+		protoQuals[column] = &proto.Quals{Quals: []*proto.Qual{{
+			FieldName: column,
+			Operator:  &proto.Qual_StringValue{StringValue: "="},
+			Value:     qualValue,
+		}}}
+	}
+
+	stream := &rowStream{ctx: context.Background()}
+	err := p.server.Execute(&proto.ExecuteRequest{
+		Table:        table,
+		QueryContext: &proto.QueryContext{Columns: columns, Quals: protoQuals},
+		Connection:   ConnectionName,
+		CallId:       fmt.Sprintf("crctest-%s", table),
+		ExecuteConnectionData: map[string]*proto.ExecuteConnectionData{
+			ConnectionName: {Limit: &proto.NullableInt{Value: limit}, CacheEnabled: false},
+		},
+	}, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0, len(stream.rows))
+	for _, protoRow := range stream.rows {
+		row := Row{}
+		for name, column := range protoRow.Columns {
+			value, err := fromColumn(column)
+			if err != nil {
+				return nil, fmt.Errorf("error reading column %s: %v", name, err)
+			}
+			row[name] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// toQualValue converts a qual value to its protobuf representation
+func toQualValue(value interface{}) (*proto.QualValue, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		jsonb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.QualValue{Value: &proto.QualValue_JsonbValue{JsonbValue: string(jsonb)}}, nil
+	case string:
+		return &proto.QualValue{Value: &proto.QualValue_StringValue{StringValue: v}}, nil
+	case []string:
+		list := &proto.QualValueList{}
+		for _, item := range v {
+			list.Values = append(list.Values, &proto.QualValue{Value: &proto.QualValue_StringValue{StringValue: item}})
+		}
+		return &proto.QualValue{Value: &proto.QualValue_ListValue{ListValue: list}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported qual value %v of type %T", value, value)
+	}
+}
+
+// fromColumn converts a column value from its protobuf representation
+func fromColumn(column *proto.Column) (interface{}, error) {
+	switch v := column.Value.(type) {
+	case *proto.Column_NullValue:
+		return nil, nil
+	case *proto.Column_DoubleValue:
+		return v.DoubleValue, nil
+	case *proto.Column_IntValue:
+		return v.IntValue, nil
+	case *proto.Column_StringValue:
+		return v.StringValue, nil
+	case *proto.Column_BoolValue:
+		return v.BoolValue, nil
+	case *proto.Column_JsonValue:
+		var value interface{}
+		err := json.Unmarshal(v.JsonValue, &value)
+		return value, err
+	case *proto.Column_TimestampValue:
+		return v.TimestampValue.AsTime(), nil
+	default:
+		return nil, fmt.Errorf("unsupported column value %T", column.Value)
+	}
+}
+
+// rowStream collects the rows sent by the plugin as a gRPC server stream would
+type rowStream struct {
+	grpclib.ServerStream
+	ctx  context.Context
+	rows []*proto.Row
+}
+
+func (s *rowStream) Send(resp *proto.ExecuteResponse) error {
+	if resp != nil && resp.Row != nil {
+		s.rows = append(s.rows, resp.Row)
+	}
+	return nil
+}
+
+func (s *rowStream) Context() context.Context {
+	return s.ctx
+}
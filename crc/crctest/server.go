@@ -0,0 +1,294 @@
+// Package crctest provides a local stand-in for console.redhat.com and
+// sso.redhat.com, and a harness running queries against the plugin tables
+// through the Steampipe plugin SDK, to test the tables end to end.
+package crctest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The identifiers the fixtures are served for
+const (
+	ClusterID  = "0b3f7d1c-2a5e-4c8f-9d6b-1e2f3a4b5c6d"
+	CVEName    = "CVE-2023-44487"
+	OCPVersion = "4.14.0"
+)
+
+// The credentials accepted by the token endpoint
+const (
+	ClientID     = "crctest-client"
+	ClientSecret = "crctest-secret"
+	// AccessToken is an unsigned JWT claiming OrgID, AccountNumber, Username
+	// and the openid, api.console and api.iam.service_accounts scopes, expiring in 2100
+	AccessToken = "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0.eyJhY2NvdW50X251bWJlciI6IjU5MTA1MzgiLCJjbGllbnRfaWQiOiJjcmN0ZXN0LWNsaWVudCIsImV4cCI6NDEwMjQ0NDgwMCwib3JnYW5pemF0aW9uIjp7ImlkIjoiMTE3ODk3NzIifSwicHJlZmVycmVkX3VzZXJuYW1lIjoiY3JjdGVzdC11c2VyIiwic2NvcGUiOiJvcGVuaWQgYXBpLmNvbnNvbGUgYXBpLmlhbS5zZXJ2aWNlX2FjY291bnRzIn0.crctest"
+)
+
+// The identity claimed by AccessToken
+const (
+	OrgID         = "11789772"
+	AccountNumber = "5910538"
+	Username      = "crctest-user"
+)
+
+// TokenPath is the path of the SSO token endpoint
+const TokenPath = "/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// DefaultMaxPageSize is the maximum number of items returned per page by the
+// paginated endpoints, whatever the requested limit
+const DefaultMaxPageSize = 2
+
+// paginatedPrefix is the prefix of the endpoints supporting limit/offset pagination
+const paginatedPrefix = "/api/ocp-vulnerability/"
+
+//go:embed testdata/*.json
+var testdata embed.FS
+
+// defaultFixtures maps the paths served by default to their fixture
+var defaultFixtures = map[string]string{
+	"/api/insights-results-aggregator/v2/clusters":                          "aggregator_clusters.json",
+	"/api/insights-results-aggregator/v2/cluster/" + ClusterID + "/reports": "aggregator_cluster_reports.json",
+	"/api/gathering/v1/gathering_rules":                                     "gathering_rules_v1.json",
+	"/api/gathering/v2/" + OCPVersion + "/gathering_rules":                  "gathering_rules_v2.json",
+	"/api/ocp-vulnerability/v1/clusters":                                    "vulnerability_clusters.json",
+	"/api/ocp-vulnerability/v1/clusters/" + ClusterID + "/cves":             "vulnerability_cluster_cves.json",
+	"/api/ocp-vulnerability/v1/clusters/" + ClusterID + "/exposed_images":   "vulnerability_cluster_exposed_images.json",
+	"/api/ocp-vulnerability/v1/cves":                                        "vulnerability_cves.json",
+	"/api/ocp-vulnerability/v1/cves/" + CVEName + "/exposed_clusters":       "vulnerability_cve_exposed_clusters.json",
+	"/api/ocp-vulnerability/v1/cves/" + CVEName + "/exposed_images":         "vulnerability_cve_exposed_images.json",
+	"/api/entitlements/v1/services":                                         "entitlements_services.json",
+}
+
+// Failure is an error the server can be told to return
+type Failure int
+
+const (
+	// Unauthorized responds with a 401, as for an expired token
+	Unauthorized Failure = iota + 1
+	// TooManyRequests responds with a 429 asking to retry immediately
+	TooManyRequests
+	// InternalServerError responds with a 500
+	InternalServerError
+	// MalformedJSON responds with a 200 whose body isn't valid JSON
+	MalformedJSON
+	// Slow responds normally after SlowResponseDelay
+	Slow
+)
+
+// SlowResponseDelay is how long the Slow failure delays the responses
+const SlowResponseDelay = 200 * time.Millisecond
+
+// injectedFailure is a failure returned for the next requests to a path
+type injectedFailure struct {
+	failure Failure
+	times   int
+}
+
+// Server is a fake console.redhat.com serving the SSO token endpoint and
+// every endpoint called by the plugin
+type Server struct {
+	*httptest.Server
+
+	// MaxPageSize caps the number of items returned per page by the paginated endpoints
+	MaxPageSize int
+
+	mu       sync.Mutex
+	fixtures map[string][]byte
+	failures map[string]*injectedFailure
+	requests []string
+}
+
+// NewServer starts a server with the default fixtures, closed at the end of the test
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	s := &Server{
+		MaxPageSize: DefaultMaxPageSize,
+		fixtures:    map[string][]byte{},
+		failures:    map[string]*injectedFailure{},
+	}
+	for path, name := range defaultFixtures {
+		fixture, err := testdata.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatalf("error reading fixture %s: %v", name, err)
+		}
+		s.fixtures[path] = fixture
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetFixture serves the body for the path, replacing its fixture if any
+func (s *Server) SetFixture(path, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[path] = []byte(body)
+}
+
+// Fail returns the failure for the next requests to the path, which may be TokenPath
+func (s *Server) Fail(path string, failure Failure, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = &injectedFailure{failure: failure, times: times}
+}
+
+// Requests returns the method, path and query of every request received so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Config returns a connection configuration pointing to the server, followed
+// by any extra HCL attributes
+func (s *Server) Config(extra ...string) string {
+	lines := append([]string{
+		fmt.Sprintf("base_url = %q", s.URL+"/"),
+		fmt.Sprintf("token_url = %q", s.URL+TokenPath),
+		"allow_insecure_token_url = true",
+		fmt.Sprintf("client_id = %q", ClientID),
+		fmt.Sprintf("client_secret = %q", ClientSecret),
+		`retry_base_delay = "1ms"`,
+	}, extra...)
+	return strings.Join(lines, "\n")
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, strings.TrimSuffix(r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery, "?"))
+	requestID := fmt.Sprintf("crctest-%d", len(s.requests))
+	failure := s.nextFailure(r.URL.Path)
+	fixture, found := s.fixtures[r.URL.Path]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("x-rh-insights-request-id", requestID)
+	switch failure {
+	case Unauthorized:
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	case TooManyRequests:
+		w.Header().Set("Retry-After", "0")
+		writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+		return
+	case InternalServerError:
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	case MalformedJSON:
+		fmt.Fprint(w, `{"data": [{"id": `)
+		return
+	case Slow:
+		time.Sleep(SlowResponseDelay)
+	}
+
+	if r.URL.Path == TokenPath {
+		s.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, paginatedPrefix) && r.URL.Query().Has("limit") {
+		page, err := s.paginate(fixture, r.URL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fixture = page
+	}
+	w.Write(fixture)
+}
+
+// nextFailure returns the failure to return for the path, if any. s.mu must be held.
+func (s *Server) nextFailure(path string) Failure {
+	injected, ok := s.failures[path]
+	if !ok || injected.times <= 0 {
+		return 0
+	}
+	injected.times--
+	return injected.failure
+}
+
+// serveToken implements the client credentials grant of the SSO token endpoint
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != ClientID || secret != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	fmt.Fprintf(w, `{"access_token": %q, "expires_in": 900, "token_type": "Bearer"}`, AccessToken)
+}
+
+// paginate returns the page of the fixture requested by the limit and offset
+// query parameters, with the metadata and links of the ocp-vulnerability service
+func (s *Server) paginate(fixture []byte, u *url.URL) ([]byte, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(fixture, &document); err != nil {
+		return nil, fmt.Errorf("fixture isn't a JSON object: %v", err)
+	}
+	items, _ := document["data"].([]interface{})
+
+	limit, err := strconv.Atoi(u.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		return nil, fmt.Errorf("invalid limit %q", u.Query().Get("limit"))
+	}
+	offset, err := strconv.Atoi(u.Query().Get("offset"))
+	if err != nil && u.Query().Has("offset") || offset < 0 {
+		return nil, fmt.Errorf("invalid offset %q", u.Query().Get("offset"))
+	}
+	if s.MaxPageSize > 0 && limit > s.MaxPageSize {
+		limit = s.MaxPageSize
+	}
+
+	start, end := min(offset, len(items)), min(offset+limit, len(items))
+	link := func(offset int) string {
+		query := u.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+		return u.Path + "?" + query.Encode()
+	}
+	links := map[string]interface{}{
+		"first": link(0),
+		"last":  link(max(len(items)-1, 0) / limit * limit),
+	}
+	if end < len(items) {
+		links["next"] = link(end)
+	}
+	if start > 0 {
+		links["previous"] = link(max(start-limit, 0))
+	}
+
+	document["data"] = items[start:end]
+	document["meta"] = map[string]interface{}{"limit": limit, "offset": offset, "total_items": len(items)}
+	document["links"] = links
+	return json.Marshal(document)
+}
+
+// writeError writes an error response in the format of the console.redhat.com services
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors": [{"status": "%d", "detail": %q}]}`, status, detail)
+}
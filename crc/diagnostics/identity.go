@@ -0,0 +1,194 @@
+package diagnostics
+
+import (
+	"context"
+	"sort"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+const IdentityTableName = "crc_identity"
+
+// entitlementsEndpoint lists the services the organization is entitled to,
+// a lightweight request any authenticated user can send
+const entitlementsEndpoint = "api/entitlements/v1/services"
+
+// entitlement is the entitlement of the organization to a service
+type entitlement struct {
+	IsEntitled bool `json:"is_entitled"`
+	IsTrial    bool `json:"is_trial"`
+}
+
+// identityRow is the identity of the connection along with the result of
+// the request checking its access
+type identityRow struct {
+	utils.Identity
+	AccessVerified   bool
+	AccessError      string
+	Entitlements     map[string]entitlement
+	EntitledServices []string
+}
+
+func TableIdentity(_ context.Context) *plugin.Table {
+	table := &plugin.Table{
+		Name:        IdentityTableName,
+		Description: "Tells who the connection is authenticated as, decoded from its access token, whether it can access console.redhat.com and where each of its settings comes from.",
+		Tags:        utils.ServiceTags(utils.ServiceEntitlements),
+		List: &plugin.ListConfig{
+			Hydrate:    listIdentity,
+			KeyColumns: utils.WithCommonKeyColumns(nil),
+		},
+		Columns: utils.WithCommonColumns([]*plugin.Column{
+			{
+				Name:        "base_url",
+				Type:        proto.ColumnType_STRING,
+				Description: "The effective base URL of the console.redhat.com APIs.",
+				Transform:   transform.FromField("BaseURL"),
+			},
+			{
+				Name:        "token_url",
+				Type:        proto.ColumnType_STRING,
+				Description: "The effective URL of the SSO token endpoint.",
+				Transform:   transform.FromField("TokenURL"),
+			},
+			{
+				Name:        "credentials",
+				Type:        proto.ColumnType_STRING,
+				Description: "The kind of credentials the connection authenticates with: client_credentials, offline_token or ocm.",
+				Transform:   transform.FromField("Credentials").NullIfZero(),
+			},
+			{
+				Name:        "setting_sources",
+				Type:        proto.ColumnType_JSON,
+				Description: "Where each setting comes from, by option name: config, an environment variable (e.g. env:CRC_URL), the ocm CLI configuration (e.g. ocm:/home/me/.config/ocm/ocm.json), replay or, for org_id, the access token.",
+				Transform:   transform.FromField("Sources"),
+			},
+			{
+				Name:        "authentication_error",
+				Type:        proto.ColumnType_STRING,
+				Description: "The error returned by the SSO server if no access token could be obtained.",
+				Transform:   transform.FromField("AuthenticationError").NullIfZero(),
+			},
+			{
+				Name:        "token_org_id",
+				Type:        proto.ColumnType_STRING,
+				Description: "The organization ID claimed by the access token, which org_id overrides when set in the connection configuration.",
+				Transform:   transform.FromField("OrgID").NullIfZero(),
+			},
+			{
+				Name:        "account_number",
+				Type:        proto.ColumnType_STRING,
+				Description: "The account number claimed by the access token.",
+				Transform:   transform.FromField("AccountNumber").NullIfZero(),
+			},
+			{
+				Name:        "username",
+				Type:        proto.ColumnType_STRING,
+				Description: "The preferred username claimed by the access token.",
+				Transform:   transform.FromField("Username").NullIfZero(),
+			},
+			{
+				Name:        "scopes",
+				Type:        proto.ColumnType_JSON,
+				Description: "The scopes granted to the access token.",
+				Transform:   transform.FromField("Scopes"),
+			},
+			{
+				Name:        "token_expires_at",
+				Type:        proto.ColumnType_TIMESTAMP,
+				Description: "When the access token expires.",
+				Transform:   transform.FromField("TokenExpiry").NullIfZero(),
+			},
+			{
+				Name:        "claims",
+				Type:        proto.ColumnType_JSON,
+				Description: "All the claims of the access token, if it is a JWT. Its signature is not verified.",
+				Transform:   transform.FromField("Claims"),
+			},
+			{
+				Name:        "access_verified",
+				Type:        proto.ColumnType_BOOL,
+				Description: "True if the access token was accepted by the entitlements service.",
+				Transform:   transform.FromField("AccessVerified"),
+			},
+			{
+				Name:        "access_error",
+				Type:        proto.ColumnType_STRING,
+				Description: "The error returned by the entitlements service, if the access couldn't be verified.",
+				Transform:   transform.FromField("AccessError").NullIfZero(),
+			},
+			{
+				Name:        "entitled_services",
+				Type:        proto.ColumnType_JSON,
+				Description: "The services the organization is entitled to, e.g. openshift.",
+				Transform:   transform.FromField("EntitledServices"),
+			},
+			{
+				Name:        "entitlements",
+				Type:        proto.ColumnType_JSON,
+				Description: "The entitlement of the organization to each service, as returned by the entitlements service.",
+				Transform:   transform.FromField("Entitlements"),
+			},
+		}),
+	}
+
+	// the organization ID is read from the identity, so that the row is
+	// returned even if the connection can't authenticate
+	for _, column := range table.Columns {
+		if column.Name == "org_id" {
+			column.Hydrate = nil
+			column.Transform = transform.FromField("ConnectionOrgID").NullIfZero()
+		}
+	}
+	return table
+}
+
+func listIdentity(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	ctx, span := utils.StartHydrateSpan(ctx, d, "listIdentity")
+	defer span.End()
+
+	identity, err := utils.GetIdentity(ctx, d)
+	if err != nil {
+		utils.LogErrorUsingSteampipeLogger(ctx, IdentityTableName, "connection_error", err)
+		return nil, err
+	}
+
+	row := identityRow{Identity: *identity}
+	if identity.AuthenticationError == "" {
+		row.Entitlements, err = getEntitlements(ctx, d)
+		if err != nil {
+			row.AccessError = err.Error()
+		} else {
+			row.AccessVerified = true
+			for service, entitlement := range row.Entitlements {
+				if entitlement.IsEntitled {
+					row.EntitledServices = append(row.EntitledServices, service)
+				}
+			}
+			sort.Strings(row.EntitledServices)
+		}
+	}
+
+	utils.StreamListItem(ctx, d, row)
+	return nil, nil
+}
+
+// getEntitlements returns the entitlements of the organization to each
+// service. The response is never served from the disk cache, which could
+// report the access of a revoked token or of another organization.
+func getEntitlements(ctx context.Context, d *plugin.QueryData) (map[string]entitlement, error) {
+	resp, err := utils.MakeAPIRequest(utils.WithoutDiskCache(ctx), d, "GET", entitlementsEndpoint, nil, utils.DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entitlements map[string]entitlement
+	if err := utils.DecodeJSON(d, resp.Body, &entitlements); err != nil {
+		return nil, err
+	}
+	return entitlements, nil
+}
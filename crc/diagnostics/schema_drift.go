@@ -0,0 +1,80 @@
+package diagnostics
+
+import (
+	"context"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+const SchemaDriftTableName = "crc_schema_drift"
+
+func TableSchemaDrift(_ context.Context) *plugin.Table {
+	return &plugin.Table{
+		Name:        SchemaDriftTableName,
+		Description: "Lists the fields the API responses added or removed compared to the tables of the plugin, as found by the queries of the connection run with 'strict_decode' enabled since the plugin started.",
+		List: &plugin.ListConfig{
+			Hydrate: listSchemaDrift,
+		},
+		Columns: []*plugin.Column{
+			{
+				Name:        "table_name",
+				Type:        proto.ColumnType_STRING,
+				Description: "The table whose query decoded the responses.",
+				Transform:   transform.FromField("Table"),
+			},
+			{
+				Name:        "endpoint",
+				Type:        proto.ColumnType_STRING,
+				Description: "The endpoint template the responses came from, e.g. api/ocp-vulnerability/v1/clusters/{id}/cves.",
+				Transform:   transform.FromField("Endpoint"),
+			},
+			{
+				Name:        "field",
+				Type:        proto.ColumnType_STRING,
+				Description: "The path of the field in the response, with [] for the elements of arrays and * for the values of objects, e.g. data[].extra_data.error_key.",
+				Transform:   transform.FromField("Field"),
+			},
+			{
+				Name:        "kind",
+				Type:        proto.ColumnType_STRING,
+				Description: "Either unknown, for a field the plugin doesn't know about, or missing, for a field the plugin expects but the API no longer returns.",
+				Transform:   transform.FromField("Kind"),
+			},
+			{
+				Name:        "occurrences",
+				Type:        proto.ColumnType_INT,
+				Description: "The number of decoded values showing the drift.",
+				Transform:   transform.FromField("Occurrences"),
+			},
+			{
+				Name:        "first_seen",
+				Type:        proto.ColumnType_TIMESTAMP,
+				Description: "When the drift was first found.",
+				Transform:   transform.FromField("FirstSeen"),
+			},
+			{
+				Name:        "last_seen",
+				Type:        proto.ColumnType_TIMESTAMP,
+				Description: "When the drift was last found.",
+				Transform:   transform.FromField("LastSeen"),
+			},
+		},
+	}
+}
+
+func listSchemaDrift(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	ctx, span := utils.StartHydrateSpan(ctx, d, "listSchemaDrift")
+	defer span.End()
+
+	for _, drift := range utils.SchemaDrifts(d.Connection.Name) {
+		utils.StreamListItem(ctx, d, drift)
+		if d.RowsRemaining(ctx) == 0 {
+			break
+		}
+	}
+
+	return nil, nil
+}
@@ -1,11 +1,14 @@
 package gathering_conditions_service
 
 import (
-	"io"
-	"strings"
+	"context"
 	"testing"
 
+	"github.com/juandspy/steampipe-plugin-crc/crc/crctest"
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
 )
 
 const mockGatheringResponseV1 = `
@@ -343,21 +346,52 @@ const mockGatheringResponseV2 = `
 }
 `
 
+// testPlugin serves the tables of the package only
+func testPlugin(ctx context.Context) *plugin.Plugin {
+	return &plugin.Plugin{
+		Name:             "steampipe-plugin-crc",
+		DefaultTransform: transform.FromGo().NullIfZero(),
+		RateLimiters:     utils.RateLimiters(),
+		ConnectionConfigSchema: &plugin.ConnectionConfigSchema{
+			NewInstance: utils.ConfigInstance,
+		},
+		TableMap: map[string]*plugin.Table{
+			V1GatheringRulesTableName:      TableGatheringRulesV1(ctx),
+			V2RemoteConfigurationTableName: TableGatheringRulesV2(ctx),
+		},
+	}
+}
+
 func TestDecodeGatheringRulesV1(t *testing.T) {
-	var rules gatheringRulesV1
-	body := io.NopCloser(strings.NewReader(mockGatheringResponseV1))
-	rules, err := decodeGatheringRulesV1(body)
+	server := crctest.NewServer(t)
+	server.SetFixture("/api/gathering/v1/gathering_rules", mockGatheringResponseV1)
+	p := crctest.NewPlugin(t, testPlugin, server.Config())
+
+	rows, err := p.Query(V1GatheringRulesTableName, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, rules.Version, "1.0.1")
-	assert.Len(t, rules.Rules, 9)
+	assert.Len(t, rows, 9)
+	functions := map[string]bool{}
+	for _, row := range rows {
+		assert.Equal(t, "1.0.1", row["version"])
+		assert.Len(t, row["conditions"], 1)
+		for name := range row["gathering_functions"].(map[string]interface{}) {
+			functions[name] = true
+		}
+	}
+	assert.True(t, functions["api_request_counts_of_resource_from_alert"])
+	assert.True(t, functions["containers_logs"])
 }
 
 func TestDecodeGatheringRulesV2(t *testing.T) {
-	var rules gatheringRulesV2
-	body := io.NopCloser(strings.NewReader(mockGatheringResponseV2))
-	rules, err := decodeGatheringRulesV2(body)
+	server := crctest.NewServer(t)
+	server.SetFixture("/api/gathering/v2/"+crctest.OCPVersion+"/gathering_rules", mockGatheringResponseV2)
+	p := crctest.NewPlugin(t, testPlugin, server.Config())
+
+	rows, err := p.Query(V2RemoteConfigurationTableName, map[string]interface{}{"ocp_version": crctest.OCPVersion})
 	assert.NoError(t, err)
-	assert.Equal(t, rules.Version, "1.1.0")
-	assert.Len(t, rules.ConditionalGatheringRules, 9)
-	assert.Len(t, rules.ContainerLogs, 1)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, crctest.OCPVersion, rows[0]["ocp_version"])
+	assert.Equal(t, "1.1.0", rows[0]["version"])
+	assert.Len(t, rows[0]["conditional_gathering_rules"], 9)
+	assert.Len(t, rows[0]["container_logs"], 1)
 }
@@ -0,0 +1,217 @@
+// Package openapi generates the tables of the endpoints described by the
+// OpenAPI documents published by the console.redhat.com services, e.g.
+// https://console.redhat.com/api/ocp-vulnerability/v1/openapi.json
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Document is the subset of an OpenAPI 3 document the tables are generated from
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Servers    []Server            `json:"servers"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Server is a server of the API, whose URL path prefixes the paths of the document
+type Server struct {
+	URL       string `json:"url"`
+	Variables map[string]struct {
+		Default string `json:"default"`
+	} `json:"variables"`
+}
+
+// Components holds the definitions the document refers to with $ref
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+	Responses  map[string]*Response  `json:"responses"`
+}
+
+// PathItem holds the operations of a path
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters"`
+	Get        *Operation   `json:"get"`
+}
+
+// Operation is an operation on a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description"`
+	Parameters  []*Parameter         `json:"parameters"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path or query parameter of an operation
+type Parameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *Schema `json:"schema"`
+	} `json:"content"`
+}
+
+// Schema describes a JSON value
+type Schema struct {
+	Ref         string             `json:"$ref"`
+	Type        SchemaType         `json:"type"`
+	Format      string             `json:"format"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Properties  map[string]*Schema `json:"properties"`
+	Items       *Schema            `json:"items"`
+	AllOf       []*Schema          `json:"allOf"`
+}
+
+// SchemaType is the type of a schema. OpenAPI 3.1 allows a list of types,
+// e.g. ["string", "null"], of which the first one other than null is kept.
+type SchemaType string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var types []string
+	if err := json.Unmarshal(data, &types); err != nil {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		types = []string{single}
+	}
+	for _, typ := range types {
+		if typ != "null" {
+			*t = SchemaType(typ)
+			return nil
+		}
+	}
+	return nil
+}
+
+// maxRefDepth bounds the chains of references resolved, in case of a cycle
+const maxRefDepth = 32
+
+// LoadDocument reads the OpenAPI 3 document, in JSON, at the path
+func LoadDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document Document
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("the OpenAPI document %s isn't valid JSON: %v", path, err)
+	}
+	if !strings.HasPrefix(document.OpenAPI, "3.") {
+		return nil, fmt.Errorf("the document %s isn't an OpenAPI 3 document, only OpenAPI 3 documents in JSON are supported", path)
+	}
+	return &document, nil
+}
+
+// BasePath returns the path of the URL of the first server, without slashes
+// around it, e.g. "api/ocp-vulnerability/v1"
+func (d *Document) BasePath() string {
+	if len(d.Servers) == 0 {
+		return ""
+	}
+	server := d.Servers[0]
+	rawURL := server.URL
+	for name, variable := range server.Variables {
+		rawURL = strings.ReplaceAll(rawURL, "{"+name+"}", variable.Default)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
+
+// schema resolves the references of the schema, and merges its allOf schemas
+func (d *Document) schema(schema *Schema) *Schema {
+	return d.resolveSchema(schema, 0)
+}
+
+func (d *Document) resolveSchema(schema *Schema, depth int) *Schema {
+	if schema == nil || depth > maxRefDepth {
+		return nil
+	}
+	if schema.Ref != "" {
+		return d.resolveSchema(d.Components.Schemas[refName(schema.Ref, "schemas")], depth+1)
+	}
+	if len(schema.AllOf) == 0 {
+		return schema
+	}
+
+	merged := *schema
+	merged.AllOf = nil
+	merged.Properties = map[string]*Schema{}
+	for name, property := range schema.Properties {
+		merged.Properties[name] = property
+	}
+	for _, part := range schema.AllOf {
+		part = d.resolveSchema(part, depth+1)
+		if part == nil {
+			continue
+		}
+		if merged.Type == "" {
+			merged.Type = part.Type
+		}
+		if merged.Items == nil {
+			merged.Items = part.Items
+		}
+		for name, property := range part.Properties {
+			if _, ok := merged.Properties[name]; !ok {
+				merged.Properties[name] = property
+			}
+		}
+	}
+	return &merged
+}
+
+// parameter resolves the reference of the parameter
+func (d *Document) parameter(parameter *Parameter) *Parameter {
+	for depth := 0; parameter != nil && parameter.Ref != ""; depth++ {
+		if depth > maxRefDepth {
+			return nil
+		}
+		parameter = d.Components.Parameters[refName(parameter.Ref, "parameters")]
+	}
+	return parameter
+}
+
+// response resolves the reference of the response
+func (d *Document) response(response *Response) *Response {
+	for depth := 0; response != nil && response.Ref != ""; depth++ {
+		if depth > maxRefDepth {
+			return nil
+		}
+		response = d.Components.Responses[refName(response.Ref, "responses")]
+	}
+	return response
+}
+
+// refName returns the name of the component referred to, e.g. "Cluster" for
+// "#/components/schemas/Cluster", or an empty string for the references to
+// other documents or kinds of components
+func refName(ref, kind string) string {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	name := strings.TrimPrefix(ref, prefix)
+	// JSON pointers escape ~ and /
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+}
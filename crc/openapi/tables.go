@@ -0,0 +1,499 @@
+package openapi
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// TablePrefix prefixes the names of the generated tables, e.g.
+// crc_openapi_ocp_vulnerability_v1_clusters_cves for the
+// api/ocp-vulnerability/v1/clusters/{cluster_id}/cves endpoint
+const TablePrefix = "crc_openapi_"
+
+// services maps the path segment of the services whose name differs from
+// the one selecting their rate limiters
+var services = map[string]string{
+	"insights-results-aggregator": utils.ServiceAggregator,
+}
+
+// paginationParams are the query parameters set by the pagination, which
+// aren't key columns
+var paginationParams = map[string]bool{"limit": true, "offset": true}
+
+// pathParams match the {parameter} placeholders of the paths
+var pathParams = regexp.MustCompile(`{([^{}]+)}`)
+
+// LoadTables returns the tables generated from the OpenAPI documents found
+// at the paths, which may be globs. The paths which can't be listed and the
+// documents which can't be loaded, e.g. while they are being downloaded, are
+// skipped with a warning, as is an endpoint generating the name of a table
+// already generated.
+func LoadTables(ctx context.Context, d *plugin.TableMapData, paths []string) map[string]*plugin.Table {
+	tables := map[string]*plugin.Table{}
+	for _, path := range paths {
+		files, err := d.GetSourceFiles(path)
+		if err != nil {
+			plugin.Logger(ctx).Warn("openapi.LoadTables", "path", path, "warning", fmt.Sprintf("error listing the OpenAPI documents, skipping them: %v", err))
+			continue
+		}
+		for _, file := range files {
+			document, err := LoadDocument(file)
+			if err != nil {
+				plugin.Logger(ctx).Warn("openapi.LoadTables", "document", file, "warning", fmt.Sprintf("error loading the OpenAPI document, skipping it: %v", err))
+				continue
+			}
+			documentTables, warnings := document.Tables()
+			for _, warning := range warnings {
+				plugin.Logger(ctx).Warn("openapi.LoadTables", "document", file, "warning", warning)
+			}
+			for name, table := range documentTables {
+				if _, ok := tables[name]; ok {
+					plugin.Logger(ctx).Warn("openapi.LoadTables", "document", file, "warning", fmt.Sprintf("the table %s is already generated from another document, skipping it", name))
+					continue
+				}
+				tables[name] = table
+			}
+		}
+	}
+	return tables
+}
+
+// Tables returns a table per GET collection endpoint of the document, along
+// with the reasons why the other GET endpoints were skipped. Its path
+// parameters are the required key columns of the table and its query
+// parameters optional ones, sent to the endpoint.
+func (d *Document) Tables() (map[string]*plugin.Table, []string) {
+	paths := make([]string, 0, len(d.Paths))
+	for path := range d.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tables := map[string]*plugin.Table{}
+	var warnings []string
+	for _, path := range paths {
+		item := d.Paths[path]
+		if item.Get == nil {
+			continue
+		}
+		endpoint, err := d.endpoint(path, item)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping GET %s: %v", path, err))
+			continue
+		}
+		if endpoint == nil {
+			// not a collection
+			continue
+		}
+		if _, ok := tables[endpoint.name]; ok {
+			warnings = append(warnings, fmt.Sprintf("skipping GET %s: the table %s is already generated from another path", path, endpoint.name))
+			continue
+		}
+		tables[endpoint.name] = endpoint.table()
+	}
+	return tables, warnings
+}
+
+// collectionEndpoint is a GET endpoint returning a collection of objects
+type collectionEndpoint struct {
+	name        string
+	description string
+	service     string
+	// template is the endpoint, relative to the base URL, with the snake case
+	// name of the path parameters as placeholders
+	template string
+	// itemsPath is the path of the array of items in the response, or nil if
+	// the response is the array itself
+	itemsPath []string
+	paginated bool
+	columns   []utils.ColumnSpec
+}
+
+// endpoint returns the collection endpoint of the path, or nil if the GET
+// operation of the path doesn't return a collection of objects
+func (d *Document) endpoint(path string, item PathItem) (*collectionEndpoint, error) {
+	responseSchema := d.responseSchema(item.Get)
+	if responseSchema == nil {
+		return nil, nil
+	}
+	itemsPath, itemSchema := d.items(responseSchema)
+	if itemSchema == nil {
+		return nil, nil
+	}
+
+	e := &collectionEndpoint{
+		description: description(item.Get.Summary, item.Get.Description, "Lists the items returned by GET "+path+"."),
+		itemsPath:   itemsPath,
+	}
+
+	// the properties of the items
+	columns := map[string]utils.ColumnSpec{}
+	for name, property := range itemSchema.Properties {
+		column := snakeCase(name)
+		if column == "" || strings.Contains(name, ".") || utils.IsCommonColumn(column) {
+			continue
+		}
+		if _, ok := columns[column]; ok {
+			return nil, fmt.Errorf("the properties of the items have the same column name %s", column)
+		}
+		property = d.schema(property)
+		if property == nil {
+			property = &Schema{}
+		}
+		columns[column] = utils.ColumnSpec{
+			Name:        column,
+			Type:        columnType(property),
+			Description: description(property.Description, property.Title, fmt.Sprintf("The %s property of the items.", name)),
+			Field:       name,
+		}
+	}
+
+	parameters := d.parameters(item)
+
+	// the path parameters, replacing the properties of the same name
+	var placeholderErr error
+	template := pathParams.ReplaceAllStringFunc(path, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		column := snakeCase(name)
+		if column == "" || utils.IsCommonColumn(column) {
+			placeholderErr = fmt.Errorf("the path parameter %s can't be a column", name)
+		}
+		var parameterDescription string
+		if parameter, ok := parameters["path"][name]; ok {
+			parameterDescription = parameter.Description
+		}
+		columns[column] = utils.ColumnSpec{
+			Name:        column,
+			Type:        proto.ColumnType_STRING,
+			Description: description(parameterDescription, "", fmt.Sprintf("The %s path parameter of the endpoint.", name)),
+		}
+		return "{" + column + "}"
+	})
+	if placeholderErr != nil {
+		return nil, placeholderErr
+	}
+	pathColumns := map[string]bool{}
+	for _, match := range pathParams.FindAllStringSubmatch(template, -1) {
+		if pathColumns[match[1]] {
+			return nil, fmt.Errorf("the path parameters have the same column name %s", match[1])
+		}
+		pathColumns[match[1]] = true
+	}
+
+	// the query parameters, sent along with the equality quals of their column
+	queryNames := make([]string, 0, len(parameters["query"]))
+	for name := range parameters["query"] {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		if paginationParams[name] {
+			e.paginated = e.paginated || name == "limit"
+			continue
+		}
+		parameter := parameters["query"][name]
+		column := snakeCase(name)
+		if column == "" || pathColumns[column] || utils.IsCommonColumn(column) {
+			continue
+		}
+		typ := queryParamType(d.schema(parameter.Schema))
+		spec, ok := columns[column]
+		if !ok {
+			spec = utils.ColumnSpec{
+				Name:        column,
+				Type:        typ,
+				Description: description(parameter.Description, "", fmt.Sprintf("The %s query parameter of the endpoint.", name)),
+			}
+		} else if spec.Type != typ {
+			// the qual couldn't be both sent to the API and compared with the property
+			continue
+		}
+		spec.QueryParam = name
+		columns[column] = spec
+	}
+
+	e.template = strings.Trim(d.BasePath()+"/"+strings.TrimLeft(template, "/"), "/")
+	e.name, e.service = tableName(e.template)
+
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.columns = append(e.columns, columns[name])
+	}
+	return e, nil
+}
+
+// table returns the table of the endpoint
+func (e *collectionEndpoint) table() *plugin.Table {
+	if e.itemsPath == nil {
+		// the arrays returned as the whole response aren't paginated
+		return utils.EndpointTable[[]map[string]interface{}]{
+			Name:        e.name,
+			Description: e.description,
+			Service:     e.service,
+			Endpoint:    e.template,
+			Document:    true,
+			Rows: func(items []map[string]interface{}) []interface{} {
+				rows := make([]interface{}, len(items))
+				for i, item := range items {
+					rows[i] = item
+				}
+				return rows
+			},
+			Columns: e.columns,
+		}.Table()
+	}
+	var pageSize int
+	if e.paginated {
+		pageSize = utils.DefaultPageSize
+	}
+	return utils.EndpointTable[map[string]interface{}]{
+		Name:        e.name,
+		Description: e.description,
+		Service:     e.service,
+		Endpoint:    e.template,
+		ItemsPath:   e.itemsPath,
+		PageSize:    pageSize,
+		Columns:     e.columns,
+	}.Table()
+}
+
+// responseSchema returns the schema of the JSON response of the operation
+// when it succeeds, if any
+func (d *Document) responseSchema(operation *Operation) *Schema {
+	codes := make([]string, 0, len(operation.Responses))
+	for code := range operation.Responses {
+		if code == "200" || code == "2XX" || strings.HasPrefix(code, "2") && len(code) == 3 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if len(codes) == 0 {
+		return nil
+	}
+
+	response := d.response(operation.Responses[codes[0]])
+	if response == nil {
+		return nil
+	}
+	if content, ok := response.Content["application/json"]; ok {
+		return d.schema(content.Schema)
+	}
+	mediaTypes := make([]string, 0, len(response.Content))
+	for mediaType := range response.Content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	for _, mediaType := range mediaTypes {
+		if strings.Contains(mediaType, "json") {
+			return d.schema(response.Content[mediaType].Schema)
+		}
+	}
+	return nil
+}
+
+// items returns the path of the array of objects in the response and the
+// schema of the objects, if the response is a collection. The response is
+// either the array itself, or an object holding it in its data property or
+// in its only array property, e.g. report.data for the cluster reports.
+func (d *Document) items(response *Schema) ([]string, *Schema) {
+	if response.Type == "array" {
+		if item := d.objectSchema(response.Items); item != nil {
+			return nil, item
+		}
+		return nil, nil
+	}
+
+	var candidates [][]string
+	var find func(schema *Schema, path []string)
+	find = func(schema *Schema, path []string) {
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property := d.schema(schema.Properties[name])
+			if property == nil {
+				continue
+			}
+			propertyPath := append(append([]string{}, path...), name)
+			if property.Type == "array" {
+				if d.objectSchema(property.Items) != nil {
+					candidates = append(candidates, propertyPath)
+				}
+			} else if len(path) == 0 && len(property.Properties) > 0 {
+				find(property, propertyPath)
+			}
+		}
+	}
+	find(response, nil)
+
+	var itemsPath []string
+	for _, candidate := range candidates {
+		if candidate[len(candidate)-1] == "data" && (itemsPath == nil || len(candidate) < len(itemsPath)) {
+			itemsPath = candidate
+		}
+	}
+	if itemsPath == nil && len(candidates) == 1 {
+		itemsPath = candidates[0]
+	}
+	if itemsPath == nil {
+		return nil, nil
+	}
+
+	schema := response
+	for _, name := range itemsPath {
+		schema = d.schema(schema.Properties[name])
+	}
+	return itemsPath, d.objectSchema(schema.Items)
+}
+
+// objectSchema returns the resolved schema if it describes objects with properties
+func (d *Document) objectSchema(schema *Schema) *Schema {
+	schema = d.schema(schema)
+	if schema == nil || len(schema.Properties) == 0 {
+		return nil
+	}
+	return schema
+}
+
+// parameters returns the resolved parameters of the GET operation of the
+// path, by location and name. The parameters of the operation override the
+// ones of the path.
+func (d *Document) parameters(item PathItem) map[string]map[string]*Parameter {
+	parameters := map[string]map[string]*Parameter{}
+	for _, parameter := range append(append([]*Parameter{}, item.Parameters...), item.Get.Parameters...) {
+		parameter = d.parameter(parameter)
+		if parameter == nil || parameter.Name == "" {
+			continue
+		}
+		if parameters[parameter.In] == nil {
+			parameters[parameter.In] = map[string]*Parameter{}
+		}
+		parameters[parameter.In][parameter.Name] = parameter
+	}
+	return parameters
+}
+
+// tableName returns the name of the table of the endpoint, made of its path
+// segments other than api and the parameters, along with the service of the
+// endpoint
+func tableName(endpoint string) (string, string) {
+	segments := strings.Split(endpoint, "/")
+	var service string
+	if len(segments) > 1 && segments[0] == "api" {
+		service = segments[1]
+		if name, ok := services[service]; ok {
+			service = name
+		}
+		segments = segments[1:]
+	}
+
+	var words []string
+	for _, segment := range segments {
+		if pathParams.MatchString(segment) {
+			continue
+		}
+		if word := snakeCase(segment); word != "" {
+			words = append(words, word)
+		}
+	}
+	return TablePrefix + strings.Join(words, "_"), service
+}
+
+// columnType returns the type of the column of a property
+func columnType(schema *Schema) proto.ColumnType {
+	switch schema.Type {
+	case "string":
+		if schema.Format == "date-time" {
+			return proto.ColumnType_TIMESTAMP
+		}
+		return proto.ColumnType_STRING
+	case "integer":
+		return proto.ColumnType_INT
+	case "number":
+		return proto.ColumnType_DOUBLE
+	case "boolean":
+		return proto.ColumnType_BOOL
+	default:
+		return proto.ColumnType_JSON
+	}
+}
+
+// queryParamType returns the type of the column of a query parameter. The
+// parameters which aren't numbers nor booleans are sent as they are written
+// in the qual, e.g. a comma separated list.
+func queryParamType(schema *Schema) proto.ColumnType {
+	if schema != nil {
+		switch schema.Type {
+		case "integer":
+			return proto.ColumnType_INT
+		case "number":
+			return proto.ColumnType_DOUBLE
+		case "boolean":
+			return proto.ColumnType_BOOL
+		}
+	}
+	return proto.ColumnType_STRING
+}
+
+// description returns the first non empty description, on a single line and
+// ending with a period
+func description(descriptions ...string) string {
+	for _, description := range descriptions {
+		description = strings.Join(strings.Fields(description), " ")
+		if description == "" {
+			continue
+		}
+		if !strings.HasSuffix(description, ".") {
+			description += "."
+		}
+		return description
+	}
+	return ""
+}
+
+// snakeCase converts a name to snake case, e.g. clusterID and cluster-id to
+// cluster_id, dropping the characters which can't be in a column name
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			previousLower := i > 0 && (isLower(runes[i-1]) || isDigit(runes[i-1]))
+			acronymEnd := i > 0 && i+1 < len(runes) && isUpper(runes[i-1]) && isLower(runes[i+1])
+			if previousLower || acronymEnd {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r - 'A' + 'a')
+		case isLower(r) || isDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	var words []string
+	for _, word := range strings.Split(b.String(), "_") {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, "_")
+}
+
+func isLower(r rune) bool { return r >= 'a' && r <= 'z' }
+func isUpper(r rune) bool { return r >= 'A' && r <= 'Z' }
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
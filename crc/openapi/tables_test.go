@@ -0,0 +1,154 @@
+package openapi
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"cluster_id":    "cluster_id",
+		"clusterId":     "cluster_id",
+		"clusterID":     "cluster_id",
+		"HTTPServer":    "http_server",
+		"cvss3_score":   "cvss3_score",
+		"ocp-vuln":      "ocp_vuln",
+		"--weird__Name": "weird_name",
+		"v1":            "v1",
+		"$":             "",
+	}
+	for name, expected := range tests {
+		assert.Equal(t, expected, snakeCase(name), name)
+	}
+}
+
+func TestLoadDocument(t *testing.T) {
+	document, err := LoadDocument("testdata/ocp-vulnerability.json")
+	require.NoError(t, err)
+	assert.Equal(t, "api/ocp-vulnerability/v1", document.BasePath())
+
+	dir := t.TempDir()
+	swagger := filepath.Join(dir, "swagger.json")
+	require.NoError(t, os.WriteFile(swagger, []byte(`{"swagger": "2.0", "paths": {}}`), 0o600))
+	_, err = LoadDocument(swagger)
+	assert.ErrorContains(t, err, "only OpenAPI 3 documents")
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`openapi: 3.0.3`), 0o600))
+	_, err = LoadDocument(invalid)
+	assert.ErrorContains(t, err, "isn't valid JSON")
+
+	_, err = LoadDocument(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+
+	templated := Document{Servers: []Server{{URL: "https://console.redhat.com/{basePath}/"}}}
+	templated.Servers[0].Variables = map[string]struct {
+		Default string `json:"default"`
+	}{"basePath": {Default: "api/gathering/v2"}}
+	assert.Equal(t, "api/gathering/v2", templated.BasePath())
+}
+
+func TestDocumentTables(t *testing.T) {
+	document, err := LoadDocument("testdata/ocp-vulnerability.json")
+	require.NoError(t, err)
+
+	tables, warnings := document.Tables()
+	assert.Empty(t, warnings)
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// neither the CVE details, which isn't a collection, nor the POST endpoint are tables
+	assert.Equal(t, []string{
+		"crc_openapi_ocp_vulnerability_v1_clusters",
+		"crc_openapi_ocp_vulnerability_v1_clusters_cves",
+		"crc_openapi_ocp_vulnerability_v1_feature_flags",
+	}, names)
+
+	clusters := tables["crc_openapi_ocp_vulnerability_v1_clusters"]
+	assert.Equal(t, "List the clusters of the organization.", clusters.Description)
+	assert.Equal(t, utils.ServiceTags(utils.ServiceOCPVulnerability), clusters.Tags)
+	assert.Equal(t, map[string]string{"search": plugin.Optional, "status": plugin.Optional, utils.CacheModeColumn: plugin.Optional}, keyColumns(clusters))
+	assert.Equal(t, map[string]proto.ColumnType{
+		"cves_severity":       proto.ColumnType_JSON,
+		"display_name":        proto.ColumnType_STRING,
+		"id":                  proto.ColumnType_STRING,
+		"last_seen":           proto.ColumnType_TIMESTAMP,
+		"search":              proto.ColumnType_STRING,
+		"status":              proto.ColumnType_STRING,
+		"org_id":              proto.ColumnType_STRING,
+		utils.CacheModeColumn: proto.ColumnType_STRING,
+	}, columnTypes(clusters))
+
+	cves := tables["crc_openapi_ocp_vulnerability_v1_clusters_cves"]
+	assert.Equal(t, map[string]string{"cluster_id": plugin.Required, "known_exploit": plugin.Optional, utils.CacheModeColumn: plugin.Optional}, keyColumns(cves))
+	assert.Equal(t, proto.ColumnType_DOUBLE, columnTypes(cves)["cvss3_score"])
+	assert.Equal(t, proto.ColumnType_BOOL, columnTypes(cves)["known_exploit"])
+	for _, column := range cves.Columns {
+		if column.Name == "cluster_id" {
+			assert.Equal(t, "UUID of the cluster.", column.Description)
+			assert.Equal(t, []string{"Quals.cluster_id"}, column.Transform.Transforms[0].Param)
+		}
+	}
+
+	flags := tables["crc_openapi_ocp_vulnerability_v1_feature_flags"]
+	assert.Equal(t, "Lists the items returned by GET /feature_flags.", flags.Description)
+	assert.Equal(t, proto.ColumnType_BOOL, columnTypes(flags)["enabled"])
+}
+
+func TestDocumentTablesConflicts(t *testing.T) {
+	item := &Schema{Type: "object", Properties: map[string]*Schema{
+		"clusterId":  {Type: "string"},
+		"cluster_id": {Type: "string"},
+	}}
+	collection := func(item *Schema) PathItem {
+		return PathItem{Get: &Operation{Responses: map[string]*Response{
+			"200": {Content: map[string]struct {
+				Schema *Schema `json:"schema"`
+			}{"application/json": {Schema: &Schema{Type: "array", Items: item}}}},
+		}}}
+	}
+	document := Document{Paths: map[string]PathItem{
+		"/api/a/clusters":   collection(item),
+		"/api/a/items":      collection(&Schema{Type: "object", Properties: map[string]*Schema{"id": {Type: "string"}, "org_id": {Type: "string"}}}),
+		"/api/a/{id}/items": collection(&Schema{Type: "object", Properties: map[string]*Schema{"id": {Type: "string"}}}),
+	}}
+
+	// the clusters have two properties named cluster_id, and the items of an
+	// item would have the name of the items table
+	tables, warnings := document.Tables()
+	assert.Len(t, warnings, 2)
+	if assert.Len(t, tables, 1) {
+		// the org_id property is skipped, the common column wins
+		items := tables["crc_openapi_a_items"]
+		assert.Equal(t, "a", items.Tags[utils.ServiceTag])
+		assert.Len(t, items.Columns, 3)
+	}
+}
+
+// keyColumns returns whether each key column of the List config is required
+func keyColumns(table *plugin.Table) map[string]string {
+	keyColumns := map[string]string{}
+	for _, column := range table.List.KeyColumns {
+		keyColumns[column.Name] = column.Require
+	}
+	return keyColumns
+}
+
+// columnTypes returns the type of each column of the table
+func columnTypes(table *plugin.Table) map[string]proto.ColumnType {
+	types := map[string]proto.ColumnType{}
+	for _, column := range table.Columns {
+		types[column.Name] = column.Type
+	}
+	return types
+}
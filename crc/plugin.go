@@ -4,7 +4,10 @@ import (
 	"context"
 
 	"github.com/juandspy/steampipe-plugin-crc/crc/aggregator"
+	"github.com/juandspy/steampipe-plugin-crc/crc/api"
+	"github.com/juandspy/steampipe-plugin-crc/crc/diagnostics"
 	gcs "github.com/juandspy/steampipe-plugin-crc/crc/gathering_conditions_service"
+	"github.com/juandspy/steampipe-plugin-crc/crc/openapi"
 	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
 	"github.com/juandspy/steampipe-plugin-crc/crc/vulnerabilities"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
@@ -15,21 +18,49 @@ func Plugin(ctx context.Context) *plugin.Plugin {
 	p := &plugin.Plugin{
 		Name:             "steampipe-plugin-crc",
 		DefaultTransform: transform.FromGo().NullIfZero(),
+		RateLimiters:     utils.RateLimiters(),
 		ConnectionConfigSchema: &plugin.ConnectionConfigSchema{
 			NewInstance: utils.ConfigInstance,
 		},
-		TableMap: map[string]*plugin.Table{
-			gcs.V1GatheringRulesTableName:                   gcs.TableGatheringRulesV1(ctx),
-			gcs.V2RemoteConfigurationTableName:              gcs.TableGatheringRulesV2(ctx),
-			aggregator.V2ClustersTableName:                  aggregator.TableClustersV2(ctx),
-			aggregator.V2ClusterReportsTableName:            aggregator.TableClusterReportsV2(ctx),
-			vulnerabilities.V1ClustersTableName:             vulnerabilities.TableClustersV1(ctx),
-			vulnerabilities.V1ClusterCVEsTableName:          vulnerabilities.TableClusterCVEsV1(ctx),
-			vulnerabilities.V1ClusterExposedImagesTableName: vulnerabilities.TableClusterExposedImagesV1(ctx),
-			vulnerabilities.V1CVEsTableName:                 vulnerabilities.TableCVEsV1(ctx),
-			vulnerabilities.V1CVEsExposedClustersTableName:  vulnerabilities.TableCVEsExposedClustersV1(ctx),
-			vulnerabilities.V1CVEsExposedImagesTableName:    vulnerabilities.TableCVEsExposedImagesV1(ctx),
-		},
+		// the tables generated from the OpenAPI documents depend on the connection
+		SchemaMode:   plugin.SchemaModeDynamic,
+		TableMapFunc: tableMap,
 	}
 	return p
 }
+
+// tableMap returns the tables of the connection: the tables of the plugin,
+// followed by the tables generated from the OpenAPI documents of
+// 'openapi_paths', if any. A bad document only loses its own tables.
+func tableMap(ctx context.Context, d *plugin.TableMapData) (map[string]*plugin.Table, error) {
+	// the connection fails with all its invalid options when it loads
+	config, err := utils.LoadValidConfig(d.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := staticTables(ctx)
+	for name, table := range openapi.LoadTables(ctx, d, config.OpenAPIPaths) {
+		tables[name] = table
+	}
+	return tables, nil
+}
+
+// staticTables returns the tables of the plugin, which every connection has
+func staticTables(ctx context.Context) map[string]*plugin.Table {
+	return map[string]*plugin.Table{
+		gcs.V1GatheringRulesTableName:                   gcs.TableGatheringRulesV1(ctx),
+		gcs.V2RemoteConfigurationTableName:              gcs.TableGatheringRulesV2(ctx),
+		aggregator.V2ClustersTableName:                  aggregator.TableClustersV2(ctx),
+		aggregator.V2ClusterReportsTableName:            aggregator.TableClusterReportsV2(ctx),
+		vulnerabilities.V1ClustersTableName:             vulnerabilities.TableClustersV1(ctx),
+		vulnerabilities.V1ClusterCVEsTableName:          vulnerabilities.TableClusterCVEsV1(ctx),
+		vulnerabilities.V1ClusterExposedImagesTableName: vulnerabilities.TableClusterExposedImagesV1(ctx),
+		vulnerabilities.V1CVEsTableName:                 vulnerabilities.TableCVEsV1(ctx),
+		vulnerabilities.V1CVEsExposedClustersTableName:  vulnerabilities.TableCVEsExposedClustersV1(ctx),
+		vulnerabilities.V1CVEsExposedImagesTableName:    vulnerabilities.TableCVEsExposedImagesV1(ctx),
+		diagnostics.SchemaDriftTableName:                diagnostics.TableSchemaDrift(ctx),
+		diagnostics.IdentityTableName:                   diagnostics.TableIdentity(ctx),
+		api.APIRequestTableName:                         api.TableAPIRequest(ctx),
+	}
+}
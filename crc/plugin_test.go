@@ -0,0 +1,693 @@
+package crc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juandspy/steampipe-plugin-crc/crc/aggregator"
+	"github.com/juandspy/steampipe-plugin-crc/crc/api"
+	"github.com/juandspy/steampipe-plugin-crc/crc/crctest"
+	"github.com/juandspy/steampipe-plugin-crc/crc/diagnostics"
+	gcs "github.com/juandspy/steampipe-plugin-crc/crc/gathering_conditions_service"
+	"github.com/juandspy/steampipe-plugin-crc/crc/utils"
+	"github.com/juandspy/steampipe-plugin-crc/crc/vulnerabilities"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTables(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	tests := []struct {
+		table string
+		quals map[string]interface{}
+		// the expected number of rows and some columns of one of them
+		rows  int
+		first crctest.Row
+	}{
+		{
+			table: aggregator.V2ClustersTableName,
+			rows:  2,
+			first: crctest.Row{
+				"cluster_id":         crctest.ClusterID,
+				"cluster_name":       "prod-eu-west-1",
+				"cluster_version":    "4.14.12",
+				"managed":            false,
+				"last_checked_at":    time.Date(2024, 5, 13, 8, 51, 23, 0, time.UTC),
+				"total_hit_count":    int64(3),
+				"hits_by_total_risk": map[string]interface{}{"1": 1.0, "2": 1.0, "3": 1.0, "4": 0.0},
+			},
+		},
+		{
+			table: aggregator.V2ClusterReportsTableName,
+			quals: map[string]interface{}{"cluster_id": crctest.ClusterID},
+			rows:  2,
+			first: crctest.Row{
+				"cluster_id": crctest.ClusterID,
+				"rule_id":    "ccx_rules_ocp.external.rules.nodes_requirements_check.report",
+				"created_at": time.Date(2023, 2, 14, 10, 0, 0, 0, time.UTC),
+				"total_risk": int64(3),
+				"tags":       []interface{}{"openshift", "configuration", "performance"},
+				"impacted":   time.Date(2024, 4, 2, 12, 31, 9, 0, time.UTC),
+			},
+		},
+		{
+			table: gcs.V1GatheringRulesTableName,
+			rows:  2,
+			first: crctest.Row{
+				"version": "1.0.1",
+				"conditions": []interface{}{
+					map[string]interface{}{"alert": map[string]interface{}{"name": "APIRemovedInNextEUSReleaseInUse"}, "type": "alert_is_firing"},
+				},
+			},
+		},
+		{
+			table: gcs.V2RemoteConfigurationTableName,
+			quals: map[string]interface{}{"ocp_version": crctest.OCPVersion},
+			rows:  1,
+			first: crctest.Row{
+				"ocp_version": crctest.OCPVersion,
+				"version":     "1.1.0",
+			},
+		},
+		{
+			table: vulnerabilities.V1ClustersTableName,
+			rows:  3,
+			first: crctest.Row{
+				"cluster_id":     crctest.ClusterID,
+				"display_name":   "prod-eu-west-1",
+				"version":        "4.14.12",
+				"provider":       "AWS",
+				"status":         "Ready",
+				"low_cves":       int64(2),
+				"moderate_cves":  int64(7),
+				"important_cves": int64(4),
+				"critical_cves":  int64(1),
+			},
+		},
+		{
+			table: vulnerabilities.V1ClusterCVEsTableName,
+			quals: map[string]interface{}{"cluster_id": crctest.ClusterID},
+			rows:  2,
+			first: crctest.Row{
+				"cluster_id":  crctest.ClusterID,
+				"cvss3_score": 7.5,
+				"exploits":    true,
+				"severity":    "Important",
+				"synopsis":    crctest.CVEName,
+			},
+		},
+		{
+			table: vulnerabilities.V1ClusterExposedImagesTableName,
+			quals: map[string]interface{}{"cluster_id": crctest.ClusterID},
+			rows:  2,
+			first: crctest.Row{
+				"cluster_id": crctest.ClusterID,
+				"name":       "openshift4/ose-kube-rbac-proxy",
+				"registry":   "registry.redhat.io",
+			},
+		},
+		{
+			table: vulnerabilities.V1CVEsTableName,
+			rows:  3,
+			first: crctest.Row{
+				"synopsis":         crctest.CVEName,
+				"clusters_exposed": int64(2),
+				"images_exposed":   int64(5),
+				"severity":         "Important",
+			},
+		},
+		{
+			table: vulnerabilities.V1CVEsExposedClustersTableName,
+			quals: map[string]interface{}{"cve_name": crctest.CVEName},
+			rows:  2,
+			first: crctest.Row{
+				"cve_name":     crctest.CVEName,
+				"id":           crctest.ClusterID,
+				"display_name": "prod-eu-west-1",
+				"type":         "OCP",
+			},
+		},
+		{
+			table: vulnerabilities.V1CVEsExposedImagesTableName,
+			quals: map[string]interface{}{"cve_name": crctest.CVEName},
+			rows:  2,
+			first: crctest.Row{
+				"cve_name":         crctest.CVEName,
+				"clusters_exposed": int64(2),
+				"name":             "openshift4/ose-kube-rbac-proxy",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			rows, err := p.Query(tt.table, tt.quals)
+			assert.NoError(t, err)
+			if !assert.Len(t, rows, tt.rows) {
+				return
+			}
+
+			// the rows of a list may be streamed in any order
+			var first crctest.Row
+			for _, row := range rows {
+				if matches(row, tt.first) {
+					first = row
+				}
+			}
+			if assert.NotNil(t, first, "no row matching %v in %v", tt.first, rows) {
+				assert.Equal(t, crctest.ConnectionName, first["sp_connection_name"])
+			}
+		})
+	}
+}
+
+// containsRow reports whether one of the rows contains the expected columns
+func containsRow(rows []crctest.Row, expected crctest.Row) bool {
+	for _, row := range rows {
+		if matches(row, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the row contains the expected columns
+func matches(row, expected crctest.Row) bool {
+	for column, value := range expected {
+		if !assert.ObjectsAreEqual(value, row[column]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTablesPagination(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	rows, err := p.Query(vulnerabilities.V1CVEsTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, []string{
+		"POST " + crctest.TokenPath,
+		"GET /api/ocp-vulnerability/v1/cves?limit=100&offset=0",
+		"GET /api/ocp-vulnerability/v1/cves?limit=2&offset=2",
+	}, server.Requests())
+
+	// the next page isn't requested once the limit is reached
+	rows, err = p.QueryWithLimit(vulnerabilities.V1ClustersTableName, nil, 1)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "GET /api/ocp-vulnerability/v1/clusters?limit=100&offset=0", server.Requests()[3])
+	assert.Len(t, server.Requests(), 4)
+}
+
+func TestTablesFanOut(t *testing.T) {
+	// the second cluster of the fixtures, the third one has no CVEs
+	const otherClusterID = "5f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b"
+	server := crctest.NewServer(t)
+	server.SetFixture("/api/ocp-vulnerability/v1/clusters/"+otherClusterID+"/cves", `{
+		"data": [{"synopsis": "CVE-2024-3727", "severity": "Moderate", "cvss3_score": 5.3, "publish_date": "2024-05-14T15:08:00Z"}],
+		"meta": {"total_items": 1}
+	}`)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	// without a cluster_id, the rows of every cluster are returned
+	rows, err := p.Query(vulnerabilities.V1ClusterCVEsTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.True(t, containsRow(rows, crctest.Row{"cluster_id": crctest.ClusterID, "synopsis": crctest.CVEName}), "unexpected rows %v", rows)
+	assert.True(t, containsRow(rows, crctest.Row{"cluster_id": otherClusterID, "synopsis": "CVE-2024-3727"}), "unexpected rows %v", rows)
+	assert.Subset(t, server.Requests(), []string{
+		"GET /api/ocp-vulnerability/v1/clusters?limit=100&offset=0",
+		"GET /api/ocp-vulnerability/v1/clusters/" + crctest.ClusterID + "/cves?limit=100&offset=0",
+		"GET /api/ocp-vulnerability/v1/clusters/" + otherClusterID + "/cves?limit=100&offset=0",
+	})
+
+	rows, err = p.Query(aggregator.V2ClusterReportsTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.True(t, containsRow(rows, crctest.Row{"cluster_id": crctest.ClusterID, "total_risk": int64(3)}), "unexpected rows %v", rows)
+
+	// the next clusters aren't requested once the limit is reached
+	server = crctest.NewServer(t)
+	p = crctest.NewPlugin(t, Plugin, server.Config("max_concurrency = 1"))
+	rows, err = p.QueryWithLimit(vulnerabilities.V1ClusterExposedImagesTableName, nil, 1)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	requests := server.Requests()
+	assert.Equal(t, "GET /api/ocp-vulnerability/v1/clusters/"+crctest.ClusterID+"/exposed_images?limit=100&offset=0", requests[len(requests)-1])
+	for _, request := range requests {
+		assert.NotContains(t, request, otherClusterID)
+	}
+
+	// the errors other than the ignored ones fail the query
+	server.Fail("/api/ocp-vulnerability/v1/clusters/"+crctest.ClusterID+"/cves", crctest.InternalServerError, 10)
+	_, err = p.Query(vulnerabilities.V1ClusterCVEsTableName, nil)
+	assert.ErrorContains(t, err, "status code 500")
+}
+
+func TestTablesInLists(t *testing.T) {
+	const otherClusterID = "5f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b"
+	server := crctest.NewServer(t)
+	server.SetFixture("/api/ocp-vulnerability/v1/clusters/"+otherClusterID+"/cves", `{
+		"data": [{"synopsis": "CVE-2024-3727", "severity": "Moderate", "cvss3_score": 5.3, "publish_date": "2024-05-14T15:08:00Z"}],
+		"meta": {"total_items": 1}
+	}`)
+	p := crctest.NewPlugin(t, Plugin, server.Config("max_concurrency = 2"))
+
+	// each cluster is requested once
+	rows, err := p.Query(vulnerabilities.V1ClusterCVEsTableName, map[string]interface{}{
+		"cluster_id": []string{crctest.ClusterID, otherClusterID},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.True(t, containsRow(rows, crctest.Row{"cluster_id": otherClusterID, "synopsis": "CVE-2024-3727"}), "unexpected rows %v", rows)
+	assert.ElementsMatch(t, []string{
+		"GET /api/ocp-vulnerability/v1/clusters/" + crctest.ClusterID + "/cves?limit=100&offset=0",
+		"GET /api/ocp-vulnerability/v1/clusters/" + otherClusterID + "/cves?limit=100&offset=0",
+	}, server.Requests()[1:])
+
+	// the values without any row, like a CVE unknown to the service, are skipped
+	rows, err = p.Query(vulnerabilities.V1CVEsExposedClustersTableName, map[string]interface{}{
+		"cve_name": []string{crctest.CVEName, "CVE-1999-0001"},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rows)
+	for _, row := range rows {
+		assert.Equal(t, crctest.CVEName, row["cve_name"])
+	}
+
+	// the documents are one row per version
+	rows, err = p.Query(gcs.V2RemoteConfigurationTableName, map[string]interface{}{
+		"ocp_version": []string{crctest.OCPVersion, "4.99.0"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, crctest.OCPVersion, rows[0]["ocp_version"])
+	}
+}
+
+func TestTablesOpenAPI(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config(`openapi_paths = ["openapi/testdata/*.json"]`))
+
+	// the query parameters are sent along with the pagination
+	rows, err := p.Query("crc_openapi_ocp_vulnerability_v1_clusters", map[string]interface{}{"search": "prod"})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.True(t, containsRow(rows, crctest.Row{
+		"id":            crctest.ClusterID,
+		"search":        "prod",
+		"last_seen":     time.Date(2024, 5, 13, 8, 49, 2, 148311000, time.UTC),
+		"cves_severity": map[string]interface{}{"critical": 1.0, "important": 4.0, "low": 2.0, "moderate": 7.0},
+	}), "unexpected rows %v", rows)
+	assert.Equal(t, "GET /api/ocp-vulnerability/v1/clusters?limit=100&offset=0&search=prod", server.Requests()[1])
+
+	// each value of an IN list of a query parameter is requested
+	sent := len(server.Requests())
+	rows, err = p.Query("crc_openapi_ocp_vulnerability_v1_clusters", map[string]interface{}{"search": []string{"prod", "stage"}})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 6)
+	assert.True(t, containsRow(rows, crctest.Row{"id": crctest.ClusterID, "search": "prod"}), "unexpected rows %v", rows)
+	assert.True(t, containsRow(rows, crctest.Row{"id": crctest.ClusterID, "search": "stage"}), "unexpected rows %v", rows)
+	assert.ElementsMatch(t, []string{
+		"GET /api/ocp-vulnerability/v1/clusters?limit=100&offset=0&search=prod",
+		"GET /api/ocp-vulnerability/v1/clusters?limit=2&offset=2&search=prod",
+		"GET /api/ocp-vulnerability/v1/clusters?limit=100&offset=0&search=stage",
+		"GET /api/ocp-vulnerability/v1/clusters?limit=2&offset=2&search=stage",
+	}, server.Requests()[sent:])
+
+	// the path parameters are required
+	_, err = p.Query("crc_openapi_ocp_vulnerability_v1_clusters_cves", nil)
+	assert.ErrorContains(t, err, "cluster_id")
+	rows, err = p.Query("crc_openapi_ocp_vulnerability_v1_clusters_cves", map[string]interface{}{"cluster_id": crctest.ClusterID})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.True(t, containsRow(rows, crctest.Row{"cluster_id": crctest.ClusterID, "synopsis": crctest.CVEName, "cvss3_score": 7.5}), "unexpected rows %v", rows)
+
+	// the tables of the plugin are still there
+	rows, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	// the documents which can't be loaded are skipped
+	dir := t.TempDir()
+	valid, err := os.ReadFile("openapi/testdata/ocp-vulnerability.json")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ocp-vulnerability.json"), valid, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partial.json"), valid[:len(valid)/2], 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "swagger.json"), []byte(`{"swagger": "2.0", "paths": {}}`), 0o600))
+	p = crctest.NewPlugin(t, Plugin, server.Config(fmt.Sprintf("openapi_paths = [%q]", filepath.Join(dir, "*.json"))))
+	rows, err = p.Query("crc_openapi_ocp_vulnerability_v1_clusters", nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	rows, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
+
+func TestTablesAPIRequest(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	rows, err := p.Query(api.APIRequestTableName, map[string]interface{}{"endpoint": "api/ocp-vulnerability/v1/clusters"})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, int64(200), rows[0]["status_code"])
+		assert.Equal(t, "application/json", rows[0]["headers"].(map[string]interface{})["content-type"])
+		assert.Len(t, rows[0]["response"].(map[string]interface{})["data"], 3)
+	}
+
+	// the query params are sent and the array at items_path is exploded
+	rows, err = p.Query(api.APIRequestTableName, map[string]interface{}{
+		"endpoint":     "api/ocp-vulnerability/v1/clusters",
+		"query_params": map[string]interface{}{"limit": 2, "offset": 1},
+		"items_path":   "data",
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, "data", rows[0]["items_path"])
+		assert.Equal(t, map[string]interface{}{"limit": 2.0, "offset": 1.0}, rows[0]["query_params"])
+		assert.Contains(t, rows[0]["response"], "display_name")
+	}
+	assert.Equal(t, "GET /api/ocp-vulnerability/v1/clusters?limit=2&offset=1", server.Requests()[2])
+
+	// the error responses are returned as rows
+	rows, err = p.Query(api.APIRequestTableName, map[string]interface{}{"endpoint": "api/unknown", "items_path": "data"})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, int64(404), rows[0]["status_code"])
+		assert.Equal(t, map[string]interface{}{"errors": []interface{}{map[string]interface{}{"status": "404", "detail": "Not Found"}}}, rows[0]["response"])
+	}
+
+	_, err = p.Query(api.APIRequestTableName, map[string]interface{}{"endpoint": "https://example.com/api"})
+	assert.ErrorContains(t, err, "relative to the base URL")
+}
+
+func TestTablesIdentity(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	rows, err := p.Query(diagnostics.IdentityTableName, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.True(t, matches(rows[0], crctest.Row{
+			"base_url":          server.URL + "/",
+			"token_url":         server.URL + crctest.TokenPath,
+			"credentials":       utils.CredentialsClientCredentials,
+			"org_id":            crctest.OrgID,
+			"token_org_id":      crctest.OrgID,
+			"account_number":    crctest.AccountNumber,
+			"username":          crctest.Username,
+			"scopes":            []interface{}{"openid", "api.console", "api.iam.service_accounts"},
+			"token_expires_at":  time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+			"access_verified":   true,
+			"entitled_services": []interface{}{"insights", "openshift", "rhel"},
+		}), "unexpected row %v", rows[0])
+		assert.Equal(t, "config", rows[0]["setting_sources"].(map[string]interface{})["base_url"])
+		assert.Equal(t, "token", rows[0]["setting_sources"].(map[string]interface{})["org_id"])
+	}
+	assert.Contains(t, server.Requests(), "GET /api/entitlements/v1/services")
+
+	// the failed checks are reported rather than returned
+	server.Fail("/api/entitlements/v1/services", crctest.MalformedJSON, 1)
+	rows, err = crctest.NewPlugin(t, Plugin, server.Config()).Query(diagnostics.IdentityTableName, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, false, rows[0]["access_verified"])
+		assert.NotEmpty(t, rows[0]["access_error"])
+		assert.Equal(t, crctest.Username, rows[0]["username"])
+	}
+
+	server.Fail(crctest.TokenPath, crctest.Unauthorized, 10)
+	rows, err = crctest.NewPlugin(t, Plugin, server.Config()).Query(diagnostics.IdentityTableName, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Contains(t, rows[0]["authentication_error"], "401")
+		assert.Nil(t, rows[0]["username"])
+		assert.Equal(t, false, rows[0]["access_verified"])
+	}
+
+	// the access is checked by every query, even with a disk cache
+	server = crctest.NewServer(t)
+	p = crctest.NewPlugin(t, Plugin, server.Config(fmt.Sprintf("cache_dir = %q", t.TempDir())))
+	for i := 0; i < 2; i++ {
+		rows, err = p.Query(diagnostics.IdentityTableName, nil)
+		assert.NoError(t, err)
+		if assert.Len(t, rows, 1) {
+			assert.Equal(t, true, rows[0]["access_verified"])
+		}
+	}
+	var checks int
+	for _, request := range server.Requests() {
+		if request == "GET /api/entitlements/v1/services" {
+			checks++
+		}
+	}
+	assert.Equal(t, 2, checks)
+}
+
+func TestTablesErrors(t *testing.T) {
+	clusters := "/api/ocp-vulnerability/v1/clusters"
+
+	tests := []struct {
+		name    string
+		path    string
+		failure crctest.Failure
+		times   int
+		// the expected error, or no error if empty
+		err string
+	}{
+		{name: "unauthorized", path: clusters, failure: crctest.Unauthorized, times: 1, err: "status code 401"},
+		{name: "invalid credentials", path: crctest.TokenPath, failure: crctest.Unauthorized, times: 1, err: "401"},
+		{name: "retried rate limit", path: clusters, failure: crctest.TooManyRequests, times: 2},
+		{name: "exhausted rate limit", path: clusters, failure: crctest.TooManyRequests, times: 3, err: "status code 429"},
+		{name: "internal server error", path: clusters, failure: crctest.InternalServerError, times: 1, err: "status code 500"},
+		{name: "malformed JSON", path: clusters, failure: crctest.MalformedJSON, times: 1, err: "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := crctest.NewServer(t)
+			p := crctest.NewPlugin(t, Plugin, server.Config())
+			server.Fail(tt.path, tt.failure, tt.times)
+
+			rows, err := p.Query(vulnerabilities.V1ClustersTableName, nil)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, rows, 3)
+		})
+	}
+}
+
+func TestTablesInvalidConfig(t *testing.T) {
+	server := crctest.NewServer(t)
+	config := func(baseURL string, extra ...string) string {
+		return strings.Join(append([]string{
+			fmt.Sprintf("base_url = %q", baseURL),
+			fmt.Sprintf("token_url = %q", server.URL+crctest.TokenPath),
+			fmt.Sprintf("client_id = %q", crctest.ClientID),
+			fmt.Sprintf("client_secret = %q", crctest.ClientSecret),
+		}, extra...), "\n")
+	}
+
+	// the base URL is normalized, so that a missing trailing slash doesn't break the endpoints
+	p := crctest.NewPlugin(t, Plugin, config(server.URL, "allow_insecure_token_url = true"))
+	rows, err := p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	requests := len(server.Requests())
+
+	// the invalid options fail the connection when it loads
+	_, err = crctest.LoadPlugin(Plugin, config(server.URL, "allow_insecure_token_url = true", "max_attempts = 0", "ignore_error_codes = [4040]"))
+	assert.ErrorContains(t, err, "'max_attempts' must be at least 1")
+	assert.ErrorContains(t, err, "'ignore_error_codes' must only contain HTTP status codes")
+
+	p = crctest.NewPlugin(t, Plugin, config(server.URL))
+	_, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.ErrorContains(t, err, "'token_url' must use https")
+
+	p = crctest.NewPlugin(t, Plugin, config(strings.TrimPrefix(server.URL, "http://"), "allow_insecure_token_url = true"))
+	_, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.ErrorContains(t, err, "'base_url' must be an absolute URL using the http or https scheme")
+
+	// the invalid configurations fail before sending any request
+	assert.Len(t, server.Requests(), requests)
+}
+
+func TestTablesTimeouts(t *testing.T) {
+	clusters := "/api/ocp-vulnerability/v1/clusters"
+	server := crctest.NewServer(t)
+
+	p := crctest.NewPlugin(t, Plugin, server.Config(`timeout = "50ms"`))
+	server.Fail(clusters, crctest.Slow, 1)
+	_, err := p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.ErrorContains(t, err, "timeout")
+
+	// the timeout of the service takes precedence over the global one
+	p = crctest.NewPlugin(t, Plugin, server.Config(`timeout = "50ms"`, `timeouts = { "ocp-vulnerability" = "10s" }`))
+	server.Fail(clusters, crctest.Slow, 1)
+	rows, err := p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	p = crctest.NewPlugin(t, Plugin, server.Config(`timeouts = { "aggregator" = "50ms" }`))
+	server.Fail(clusters, crctest.Slow, 1)
+	rows, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
+
+func TestTablesIgnoreNotFound(t *testing.T) {
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+
+	rows, err := p.Query(vulnerabilities.V1ClusterCVEsTableName, map[string]interface{}{"cluster_id": "unknown"})
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+
+	p = crctest.NewPlugin(t, Plugin, server.Config("ignore_error_codes = []"))
+	_, err = p.Query(vulnerabilities.V1ClusterCVEsTableName, map[string]interface{}{"cluster_id": "unknown"})
+	assert.ErrorContains(t, err, "status code 404")
+}
+
+func TestTablesDiskCache(t *testing.T) {
+	server := crctest.NewServer(t)
+	dir := t.TempDir()
+	p := crctest.NewPlugin(t, Plugin, server.Config(fmt.Sprintf("cache_dir = %q", dir)))
+	apiRequests := func() int {
+		return len(server.Requests()) - 1 // without the token request
+	}
+
+	// the second query is served from the cache
+	for i := 0; i < 2; i++ {
+		rows, err := p.Query(aggregator.V2ClustersTableName, nil)
+		assert.NoError(t, err)
+		assert.Len(t, rows, 2)
+	}
+	assert.Equal(t, 1, apiRequests())
+
+	rows, err := p.Query(aggregator.V2ClustersTableName, map[string]interface{}{"cache_mode": "refresh"})
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh", rows[0]["cache_mode"])
+	assert.Equal(t, 2, apiRequests())
+
+	_, err = p.Query(aggregator.V2ClustersTableName, map[string]interface{}{"cache_mode": "bypass"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, apiRequests())
+
+	// clearing the cache keeps the pages cached by the query itself
+	_, err = p.Query(vulnerabilities.V1CVEsTableName, nil)
+	assert.NoError(t, err)
+	_, err = p.Query(vulnerabilities.V1CVEsTableName, map[string]interface{}{"cache_mode": "clear"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, apiRequests())
+	entries, err := os.ReadDir(filepath.Join(dir, crctest.ConnectionName))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// each query clearing the cache clears it again
+	_, err = p.Query(aggregator.V2ClustersTableName, nil)
+	assert.NoError(t, err)
+	_, err = p.Query(vulnerabilities.V1CVEsTableName, map[string]interface{}{"cache_mode": "clear"})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, apiRequests())
+	entries, err = os.ReadDir(filepath.Join(dir, crctest.ConnectionName))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = p.Query(aggregator.V2ClustersTableName, map[string]interface{}{"cache_mode": "invalid"})
+	assert.ErrorContains(t, err, "'cache_mode' must be one of")
+}
+
+func TestTablesTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	utils.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { utils.SetTracerProvider(nil) })
+
+	server := crctest.NewServer(t)
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+	_, err := p.Query(vulnerabilities.V1ClusterCVEsTableName, map[string]interface{}{"cluster_id": crctest.ClusterID})
+	assert.NoError(t, err)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	hydrate := spans["list "+vulnerabilities.V1ClusterCVEsTableName]
+	assert.Contains(t, hydrate.Attributes, utils.TableAttribute.String(vulnerabilities.V1ClusterCVEsTableName))
+	assert.Contains(t, hydrate.Attributes, utils.RowsAttribute.Int64(2))
+
+	// the API request is a child of the hydrate function, named after the endpoint of the table
+	request, ok := spans["GET api/ocp-vulnerability/v1/clusters/{cluster_id}/cves"]
+	if assert.True(t, ok, "no span for the API request in %v", spans) {
+		assert.Equal(t, hydrate.SpanContext.SpanID(), request.Parent.SpanID())
+		assert.Contains(t, request.Attributes, utils.URLTemplateAttribute.String("api/ocp-vulnerability/v1/clusters/{cluster_id}/cves"))
+		assert.Contains(t, request.Attributes, utils.StatusCodeAttribute.Int(200))
+	}
+	assert.Contains(t, spans, "SSOClient.authenticate")
+
+	// the failures are recorded in the spans
+	exporter.Reset()
+	server.Fail("/api/ocp-vulnerability/v1/clusters", crctest.InternalServerError, 1)
+	_, err = p.Query(vulnerabilities.V1ClustersTableName, nil)
+	assert.Error(t, err)
+	assert.NotEmpty(t, exporter.GetSpans())
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, codes.Error, span.Status.Code, span.Name)
+	}
+}
+
+func TestTablesSchemaDrift(t *testing.T) {
+	server := crctest.NewServer(t)
+	server.SetFixture("/api/insights-results-aggregator/v2/clusters", `{
+		"data": [{"cluster_id": "`+crctest.ClusterID+`", "cluster_name": "prod", "managed": false, "hits_by_total_risk": {"1": 0, "2": 0, "3": 0, "4": 0}, "cluster_version": "4.14.12", "region": "eu-west-1"}],
+		"meta": {"count": 1},
+		"status": "ok"
+	}`)
+
+	// the responses are only checked in strict decode mode
+	p := crctest.NewPlugin(t, Plugin, server.Config())
+	_, err := p.Query(aggregator.V2ClustersTableName, nil)
+	assert.NoError(t, err)
+	drifts, err := p.Query(diagnostics.SchemaDriftTableName, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	p = crctest.NewPlugin(t, Plugin, server.Config("strict_decode = true"))
+	for i := 0; i < 2; i++ {
+		rows, err := p.Query(aggregator.V2ClustersTableName, nil)
+		assert.NoError(t, err)
+		assert.Len(t, rows, 1)
+	}
+
+	drifts, err = p.Query(diagnostics.SchemaDriftTableName, nil)
+	assert.NoError(t, err)
+	expected := []crctest.Row{
+		{"field": "data[].region", "kind": "unknown"},
+		{"field": "data[].total_hit_count", "kind": "missing"},
+	}
+	assert.Len(t, drifts, len(expected))
+	for _, columns := range expected {
+		columns["table_name"] = aggregator.V2ClustersTableName
+		columns["endpoint"] = "api/insights-results-aggregator/v2/clusters"
+		columns["occurrences"] = int64(2)
+
+		found := false
+		for _, drift := range drifts {
+			found = found || matches(drift, columns)
+		}
+		assert.True(t, found, "no drift matching %v in %v", columns, drifts)
+	}
+}
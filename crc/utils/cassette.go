@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The modes of the replay_mode option
+const (
+	ReplayModeOff    = "off"
+	ReplayModeRecord = "record"
+	ReplayModeReplay = "replay"
+)
+
+// redactedValue replaces the secrets stored in the cassettes
+const redactedValue = "REDACTED"
+
+// redactedFields are the JSON fields whose values are never stored in a cassette
+var redactedFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+}
+
+// redactedHeaders are the response headers never stored in a cassette
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Set-Cookie"}
+
+// unsafeFileNameChars are replaced in the cassette file names
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cassetteEntry is a recorded request/response pair
+type cassetteEntry struct {
+	Method     string      `json:"method"`
+	Endpoint   string      `json:"endpoint"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// cassetteTransport records the responses of the wrapped transport in the
+// cassette directory, or serves the recorded responses back without sending
+// any request, depending on the mode
+type cassetteTransport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+}
+
+// replayMode returns the replay mode of the connection
+func replayMode(config crcConfig) (string, error) {
+	if config.ReplayMode == nil || *config.ReplayMode == "" {
+		return ReplayModeOff, nil
+	}
+	switch mode := *config.ReplayMode; mode {
+	case ReplayModeOff, ReplayModeRecord, ReplayModeReplay:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("'replay_mode' must be one of %q, %q or %q, got %q", ReplayModeOff, ReplayModeRecord, ReplayModeReplay, mode)
+	}
+}
+
+// newCassetteTransport wraps the transport according to the replay mode of the connection
+func newCassetteTransport(config crcConfig, next http.RoundTripper) (http.RoundTripper, error) {
+	mode, err := replayMode(config)
+	if err != nil {
+		return nil, err
+	}
+	if mode == ReplayModeOff {
+		return next, nil
+	}
+
+	if config.CassetteDir == nil || *config.CassetteDir == "" {
+		return nil, fmt.Errorf("'cassette_dir' must be set when 'replay_mode' is %q", mode)
+	}
+	if mode == ReplayModeRecord {
+		if err := os.MkdirAll(*config.CassetteDir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating 'cassette_dir': %v", err)
+		}
+	}
+
+	return &cassetteTransport{mode: mode, dir: *config.CassetteDir, next: next}, nil
+}
+
+// withReplayDefaults fills in the settings needed to create a client, which
+// are irrelevant when replaying responses, so that no credentials are needed
+func withReplayDefaults(config crcConfig) crcConfig {
+	placeholder := "replay"
+	baseURL, tokenURL := "https://console.redhat.com/", DefaultOCMTokenURL
+
+	if config.BaseUrl == nil {
+		config.BaseUrl = &baseURL
+	}
+	if config.TokenURL == nil {
+		config.TokenURL = &tokenURL
+	}
+	if config.ClientID == nil && config.ClientSecret == nil && config.OfflineToken == nil {
+		config.ClientID = &placeholder
+		config.ClientSecret = &placeholder
+	}
+	return config
+}
+
+// markReplayDefaults records the source of the settings set by
+// withReplayDefaults, which aren't in the connection configuration
+func (s *connectionSettings) markReplayDefaults(config crcConfig) {
+	defaulted := map[string]bool{
+		"base_url":      config.BaseUrl == nil,
+		"token_url":     config.TokenURL == nil,
+		"client_id":     config.ClientID == nil,
+		"client_secret": config.ClientSecret == nil,
+	}
+	for option, isDefault := range defaulted {
+		if isDefault && s.Sources[option] == SettingSourceConfig {
+			s.Sources[option] = SettingSourceReplay
+		}
+	}
+}
+
+// RoundTrip implements the RoundTripper interface
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := cassetteEndpoint(req)
+	path := filepath.Join(t.dir, cassetteFileName(req.Method, endpoint))
+
+	if t.mode == ReplayModeReplay {
+		return t.replay(req, endpoint, path)
+	}
+	return t.record(req, endpoint, path)
+}
+
+// replay serves the recorded response of the request
+func (t *cassetteTransport) replay(req *http.Request, endpoint, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no response recorded for %s %s in %s, record it with replay_mode = %q", req.Method, endpoint, t.dir, ReplayModeRecord)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the cassette: %v", err)
+	}
+
+	var entry cassetteEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("error parsing the cassette %s: %v", path, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode)),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Header,
+		Body:          io.NopCloser(strings.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}, nil
+}
+
+// record sends the request and stores its redacted response
+func (t *cassetteTransport) record(req *http.Request, endpoint, path string) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the response to record: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	for _, name := range redactedHeaders {
+		header.Del(name)
+	}
+
+	entry := cassetteEntry{
+		Method:     req.Method,
+		Endpoint:   endpoint,
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       string(redactBody(body)),
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the cassette: %v", err)
+	}
+
+	if err := writeFileAtomically(path, data); err != nil {
+		return nil, fmt.Errorf("error writing the cassette: %v", err)
+	}
+
+	return resp, nil
+}
+
+// writeFileAtomically writes to a temporary file renamed once complete, so
+// that readers never see a partial file
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cassetteEndpoint returns the path and the sorted query of the request
+func cassetteEndpoint(req *http.Request) string {
+	endpoint := strings.TrimPrefix(req.URL.Path, "/")
+	if query := req.URL.Query().Encode(); query != "" {
+		endpoint += "?" + query
+	}
+	return endpoint
+}
+
+// cassetteFileName returns a readable and unique file name for the request
+func cassetteFileName(method, endpoint string) string {
+	hash := sha256.Sum256([]byte(method + " " + endpoint))
+	name := unsafeFileNameChars.ReplaceAllString(method+"_"+endpoint, "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return fmt.Sprintf("%s_%x.json", name, hash[:4])
+}
+
+// redactBody replaces the secrets of a JSON body, returning any other body as is
+func redactBody(body []byte) []byte {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return body
+	}
+	if !redactJSON(document) {
+		return body
+	}
+	redacted, err := json.Marshal(document)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// redactJSON replaces the secrets of a decoded JSON document in place and
+// reports whether anything was replaced
+func redactJSON(document interface{}) bool {
+	redacted := false
+	switch value := document.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if redactedFields[key] {
+				value[key] = redactedValue
+				redacted = true
+			} else if redactJSON(field) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if redactJSON(item) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cassetteClient returns an authenticated client going through a cassette in the given mode
+func cassetteClient(t *testing.T, mode, dir, tokenURL string) *http.Client {
+	t.Helper()
+	transport, err := newCassetteTransport(crcConfig{ReplayMode: &mode, CassetteDir: &dir}, http.DefaultTransport)
+	assert.NoError(t, err)
+
+	ssoClient := NewSSOClient("id", "secret", tokenURL)
+	ssoClient.Transport = transport
+	return newAuthenticatedClient(ssoClient, DefaultTimeout)
+}
+
+// readBody reads and closes the body of the response
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			fmt.Fprint(w, `{"access_token": "secret-token", "refresh_token": "secret-refresh", "expires_in": 300}`)
+			return
+		}
+		assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprintf(w, `{"data": [{"id": %q}]}`, r.URL.Query().Get("limit"))
+	}))
+	dir := filepath.Join(t.TempDir(), "cassettes")
+	url := server.URL + "/api/clusters?offset=0&limit=10"
+
+	client := cassetteClient(t, ReplayModeRecord, dir, server.URL+"/token")
+	resp, err := client.Get(url)
+	assert.NoError(t, err)
+	recorded := readBody(t, resp)
+	assert.Equal(t, `{"data": [{"id": "10"}]}`, recorded)
+
+	// one cassette for the token and one for the API request, without any secret
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "secret")
+	}
+
+	// the replay is fully offline and doesn't depend on the order of the query parameters
+	server.Close()
+	client = cassetteClient(t, ReplayModeReplay, dir, server.URL+"/token")
+	resp, err = client.Get(strings.Replace(url, "offset=0&limit=10", "limit=10&offset=0", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+	assert.Equal(t, recorded, readBody(t, resp))
+
+	_, err = client.Get(server.URL + "/api/clusters?offset=10&limit=10")
+	assert.ErrorContains(t, err, "no response recorded for GET api/clusters?limit=10&offset=10")
+}
+
+func TestCassetteConfigErrors(t *testing.T) {
+	invalid, replay := "rewind", ReplayModeReplay
+	_, err := newCassetteTransport(crcConfig{ReplayMode: &invalid}, http.DefaultTransport)
+	assert.ErrorContains(t, err, "'replay_mode' must be one of")
+
+	_, err = newCassetteTransport(crcConfig{ReplayMode: &replay}, http.DefaultTransport)
+	assert.ErrorContains(t, err, "'cassette_dir' must be set")
+
+	transport, err := newCassetteTransport(crcConfig{}, http.DefaultTransport)
+	assert.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport, transport)
+}
+
+func TestReplayDefaultsDontNeedCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+
+	settings, err := resolveConnectionSettings(withReplayDefaults(crcConfig{}))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://console.redhat.com/", settings.BaseURL)
+	_, err = settings.ssoClient()
+	assert.NoError(t, err)
+}
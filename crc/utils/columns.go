@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+// WithCommonColumns appends the columns shared by every table, which tell
+// the rows of the connections of an aggregator apart and control the disk cache
+func WithCommonColumns(columns []*plugin.Column) []*plugin.Column {
+	return append(columns,
+		&plugin.Column{
+			Name:        "org_id",
+			Type:        proto.ColumnType_STRING,
+			Description: "ID of the organization the connection is authenticated to.",
+			Hydrate:     GetOrgID,
+			Transform:   transform.FromValue(),
+		},
+		&plugin.Column{
+			Name:        CacheModeColumn,
+			Type:        proto.ColumnType_STRING,
+			Description: "How the query uses the disk cache of the connection: use (default), refresh, bypass or clear.",
+			Transform:   transform.FromQual(CacheModeColumn),
+		},
+	)
+}
+
+// IsCommonColumn reports whether the name is taken by one of the columns
+// appended by WithCommonColumns
+func IsCommonColumn(name string) bool {
+	return name == "org_id" || name == CacheModeColumn
+}
+
+// WithCommonKeyColumns appends the optional quals shared by every table
+func WithCommonKeyColumns(keyColumns plugin.KeyColumnSlice) plugin.KeyColumnSlice {
+	return append(keyColumns, &plugin.KeyColumn{Name: CacheModeColumn, Require: plugin.Optional})
+}
+
+// getOrgIDMemoized caches the organization ID of each connection
+var getOrgIDMemoized = plugin.HydrateFunc(getOrgIDUncached).Memoize()
+
+// GetOrgID returns the organization ID of the connection
+func GetOrgID(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+	return getOrgIDMemoized(ctx, d, h)
+}
+
+// getOrgIDUncached returns the 'org_id' option of the connection or, if it
+// is not set, the organization ID claimed by the access token
+func getOrgIDUncached(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	ctx, span := StartHydrateSpan(ctx, d, "GetOrgID")
+	defer span.End()
+
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return nil, err
+	}
+	if config.OrgID != nil {
+		return *config.OrgID, nil
+	}
+
+	client, err := getConsoleDotClient(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := client.sso.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		// opaque tokens don't tell which organization they belong to
+		return nil, nil
+	}
+
+	return orgIDFromClaims(claims), nil
+}
+
+// orgIDFromClaims returns the organization ID claimed by a Red Hat SSO token
+func orgIDFromClaims(claims map[string]interface{}) string {
+	if organization, ok := claims["organization"].(map[string]interface{}); ok {
+		if id, ok := organization["id"].(string); ok && id != "" {
+			return id
+		}
+	}
+	if id, ok := claims["rh-org-id"].(string); ok {
+		return id
+	}
+	return ""
+}
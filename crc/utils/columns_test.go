@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrgIDFromClaims(t *testing.T) {
+	claims, err := decodeJWTClaims(fakeJWT(`{"organization": {"id": "12345678", "account_number": "7654321"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678", orgIDFromClaims(claims))
+
+	claims, err = decodeJWTClaims(fakeJWT(`{"rh-org-id": "87654321"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "87654321", orgIDFromClaims(claims))
+
+	assert.Equal(t, "", orgIDFromClaims(map[string]interface{}{}))
+}
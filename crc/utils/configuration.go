@@ -1,30 +1,302 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 )
 
 type crcConfig struct {
-	BaseUrl      *string `hcl:"base_url"`
-	TokenURL     *string `hcl:"token_url"`
-	ClientID     *string `hcl:"client_id"`
-	ClientSecret *string `hcl:"client_secret"`
+	BaseUrl  *string `hcl:"base_url"`
+	TokenURL *string `hcl:"token_url"`
+	// AllowInsecureTokenURL allows a plain http 'token_url', e.g. of a local SSO server
+	AllowInsecureTokenURL *bool   `hcl:"allow_insecure_token_url"`
+	ClientID              *string `hcl:"client_id"`
+	ClientSecret          *string `hcl:"client_secret"`
+	OfflineToken          *string `hcl:"offline_token"`
+	OCMConfigPath         *string `hcl:"ocm_config_path"`
+	OrgID                 *string `hcl:"org_id"`
+
+	ProxyURL           *string `hcl:"proxy_url"`
+	CABundlePath       *string `hcl:"ca_bundle_path"`
+	ClientCertPath     *string `hcl:"client_cert_path"`
+	ClientKeyPath      *string `hcl:"client_key_path"`
+	InsecureSkipVerify *bool   `hcl:"insecure_skip_verify"`
+
+	IgnoreErrorCodes *[]int `hcl:"ignore_error_codes"`
+
+	ReplayMode  *string `hcl:"replay_mode"`
+	CassetteDir *string `hcl:"cassette_dir"`
+
+	CacheDir  *string            `hcl:"cache_dir"`
+	CacheTTL  *string            `hcl:"cache_ttl"`
+	CacheTTLs *map[string]string `hcl:"cache_ttls"`
+
+	MaxAttempts    *int    `hcl:"max_attempts"`
+	RetryBaseDelay *string `hcl:"retry_base_delay"`
+	RetryMaxDelay  *string `hcl:"retry_max_delay"`
+	RetryJitter    *bool   `hcl:"retry_jitter"`
+
+	StrictDecode *bool `hcl:"strict_decode"`
+
+	// MaxConcurrency bounds the requests a query sends at once when it fans
+	// out, e.g. to the reports of every cluster
+	MaxConcurrency *int `hcl:"max_concurrency"`
+
+	Timeout         *string            `hcl:"timeout"`
+	Timeouts        *map[string]string `hcl:"timeouts"`
+	AdaptiveTimeout *bool              `hcl:"adaptive_timeout"`
+
+	TracingEndpoint *string `hcl:"tracing_endpoint"`
+	TracingInsecure *bool   `hcl:"tracing_insecure"`
+
+	// OpenAPIPaths are the OpenAPI documents, or globs of documents, whose
+	// collection endpoints are generated as tables. Their changes reload the schema.
+	OpenAPIPaths []string `hcl:"openapi_paths,optional" steampipe:"watch"`
+
+	RateLimits []rateLimitConfig `hcl:"rate_limit,block"`
+}
+
+// rateLimitConfig overrides the rate limit of a service for the connection
+type rateLimitConfig struct {
+	Service    string   `hcl:"service,label"`
+	FillRate   *float64 `hcl:"fill_rate"`
+	BucketSize *int     `hcl:"bucket_size"`
 }
 
 func ConfigInstance() interface{} {
 	return &crcConfig{}
 }
 
-// GetConfig :: retrieve and cast connection config from query data
+// GetConfig :: retrieve and cast connection config from query data, for the
+// callers which can't return an error. A configuration of an unexpected type
+// fails the connection when it loads, see LoadValidConfig, so the empty
+// configuration is returned.
 func GetConfig(connection *plugin.Connection) crcConfig {
+	config, _ := loadConfig(connection)
+	return config
+}
+
+// LoadValidConfig returns the configuration of the connection, failing with
+// all its invalid options at once, see validateConfig. It is called when the
+// connection loads, so that an invalid configuration fails the connection
+// rather than its first query.
+func LoadValidConfig(connection *plugin.Connection) (crcConfig, error) {
+	config, err := loadConfig(connection)
+	if err != nil {
+		return crcConfig{}, err
+	}
+	if err := validateConfig(config); err != nil {
+		var connectionName string
+		if connection != nil {
+			connectionName = connection.Name
+		}
+		return crcConfig{}, fmt.Errorf("invalid configuration of the connection %q:\n%w", connectionName, err)
+	}
+	return config, nil
+}
+
+// loadConfig returns the configuration of the connection, failing if it has
+// an unexpected type
+func loadConfig(connection *plugin.Connection) (crcConfig, error) {
 	if connection == nil || connection.Config == nil {
-		return crcConfig{}
+		return crcConfig{}, nil
 	}
-	config, ok := connection.Config.(crcConfig)
-	if !ok {
-		panic(fmt.Errorf("configuration could not be loaded: %v", connection.Config))
+	switch config := connection.Config.(type) {
+	case crcConfig:
+		return config.normalize()
+	case *crcConfig:
+		if config == nil {
+			return crcConfig{}, nil
+		}
+		return config.normalize()
+	default:
+		return crcConfig{}, fmt.Errorf("the configuration of the connection %q has the unexpected type %T, check that it is a connection of the crc plugin", connection.Name, connection.Config)
 	}
-	return config
+}
+
+// normalize returns a copy of the configuration whose path options have their
+// leading ~/ expanded to the home directory of the user, as a shell would
+func (config crcConfig) normalize() (crcConfig, error) {
+	var errs []error
+	for _, option := range []**string{
+		&config.OCMConfigPath, &config.CABundlePath, &config.ClientCertPath,
+		&config.ClientKeyPath, &config.CassetteDir, &config.CacheDir,
+	} {
+		if *option == nil {
+			continue
+		}
+		path, err := expandHome(**option)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		*option = &path
+	}
+	if len(config.OpenAPIPaths) > 0 {
+		paths := make([]string, len(config.OpenAPIPaths))
+		for i, path := range config.OpenAPIPaths {
+			expanded, err := expandHome(path)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			paths[i] = expanded
+		}
+		config.OpenAPIPaths = paths
+	}
+	if err := errors.Join(errs...); err != nil {
+		return crcConfig{}, err
+	}
+	return config, nil
+}
+
+// expandHome replaces the leading ~/ of the path with the home directory of
+// the user, e.g. for "~/.config/ocm/ocm.json"
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error expanding %s: %v", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// validateConfig checks the options of the connection configuration,
+// returning all the invalid ones at once. The URLs of the console and of the
+// SSO server are checked once resolved, see connectionSettings.normalize.
+func validateConfig(config crcConfig) error {
+	var errs []error
+	if _, err := replayMode(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := retryPolicyFromConfig(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := diskCacheFromConfig(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := timeoutsFromConfig(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := maxConcurrencyFromConfig(config); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateTracingEndpoint(config); err != nil {
+		errs = append(errs, err)
+	}
+	if config.ProxyURL != nil && *config.ProxyURL != "" {
+		if _, err := parseURL("proxy_url", *config.ProxyURL, "http", "https", "socks5"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if config.IgnoreErrorCodes != nil {
+		for _, code := range *config.IgnoreErrorCodes {
+			if code < 100 || code > 599 {
+				errs = append(errs, fmt.Errorf("'ignore_error_codes' must only contain HTTP status codes, between 100 and 599, got %d", code))
+			}
+		}
+	}
+
+	services := map[string]bool{}
+	for _, limit := range config.RateLimits {
+		if services[limit.Service] {
+			errs = append(errs, fmt.Errorf("the rate_limit %q block is set more than once", limit.Service))
+		}
+		services[limit.Service] = true
+		if limit.FillRate != nil && *limit.FillRate <= 0 {
+			errs = append(errs, fmt.Errorf("'fill_rate' of the rate_limit %q block must be positive, got %v", limit.Service, *limit.FillRate))
+		}
+		if limit.BucketSize != nil && *limit.BucketSize < 1 {
+			errs = append(errs, fmt.Errorf("'bucket_size' of the rate_limit %q block must be at least 1, got %d", limit.Service, *limit.BucketSize))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// normalize checks the URLs of the resolved settings, and normalizes the base
+// URL so that the endpoints can be appended to it: duplicate slashes are
+// removed and a trailing slash is added, e.g. "https://console.redhat.com"
+// becomes "https://console.redhat.com/". The token URL must use https, unless
+// 'allow_insecure_token_url' is set.
+func (s *connectionSettings) normalize(config crcConfig) error {
+	var errs []error
+
+	baseURL, err := parseURL("base_url", s.BaseURL, "http", "https")
+	if err == nil && (baseURL.RawQuery != "" || baseURL.Fragment != "") {
+		err = fmt.Errorf("'base_url' must not have a query or a fragment, got %q", s.BaseURL)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		baseURL.Path = collapseSlashes(baseURL.Path)
+		if !strings.HasSuffix(baseURL.Path, "/") {
+			baseURL.Path += "/"
+		}
+		baseURL.RawPath = ""
+		s.BaseURL = baseURL.String()
+	}
+
+	schemes := []string{"https"}
+	if config.AllowInsecureTokenURL != nil && *config.AllowInsecureTokenURL {
+		schemes = append(schemes, "http")
+	}
+	if _, err := parseURL("token_url", s.TokenURL, schemes...); err != nil {
+		if u, parseErr := url.Parse(s.TokenURL); parseErr == nil && u.Scheme == "http" {
+			err = fmt.Errorf("'token_url' must use https to keep the credentials secret, got %q. Set 'allow_insecure_token_url = true' to use a plain http SSO server", s.TokenURL)
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseURL parses the absolute URL of the option, which must use one of the schemes
+func parseURL(option, value string, schemes ...string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "://") {
+		return nil, fmt.Errorf("'%s' must be an absolute URL using the %s scheme, got %q", option, strings.Join(schemes, " or "), value)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid URL: %v", option, err)
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			if u.Host == "" {
+				return nil, fmt.Errorf("'%s' must have a host, e.g. %s://console.redhat.com/, got %q", option, scheme, value)
+			}
+			u.Scheme = scheme
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' must be an absolute URL using the %s scheme, got %q", option, strings.Join(schemes, " or "), value)
+}
+
+// collapseSlashes replaces the consecutive slashes of the path by a single one
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+// validateTracingEndpoint checks that 'tracing_endpoint' is a host:port address
+func validateTracingEndpoint(config crcConfig) error {
+	if config.TracingEndpoint == nil || *config.TracingEndpoint == "" {
+		return nil
+	}
+	endpoint := *config.TracingEndpoint
+	if _, _, err := net.SplitHostPort(endpoint); err != nil || strings.Contains(endpoint, "/") {
+		return fmt.Errorf("'tracing_endpoint' must be the host:port address of an OTLP gRPC collector, got %q", endpoint)
+	}
+	return nil
 }
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// parseConfig decodes a connection configuration the same way Steampipe does
+func parseConfig(t *testing.T, content string) crcConfig {
+	t.Helper()
+	file, diags := hclsyntax.ParseConfig([]byte(content), "crc.spc", hcl.Pos{Line: 1, Column: 1})
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	config := ConfigInstance().(*crcConfig)
+	diags = gohcl.DecodeBody(file.Body, &hcl.EvalContext{}, config)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	return *config
+}
+
+func TestParseConfig(t *testing.T) {
+	config := parseConfig(t, `
+base_url           = "https://console.redhat.com/"
+offline_token      = "offline"
+max_attempts       = 5
+ignore_error_codes = [403, 404]
+cache_ttls         = { "aggregator" = "1h", ocp-vulnerability = "30m" }
+
+rate_limit "aggregator" {
+  fill_rate = 2.5
+}
+`)
+
+	assert.Equal(t, "https://console.redhat.com/", *config.BaseUrl)
+	assert.Equal(t, "offline", *config.OfflineToken)
+	assert.Equal(t, 5, *config.MaxAttempts)
+	assert.Equal(t, []int{403, 404}, *config.IgnoreErrorCodes)
+	assert.Equal(t, map[string]string{"aggregator": "1h", "ocp-vulnerability": "30m"}, *config.CacheTTLs)
+	assert.Nil(t, config.ClientID)
+	if assert.Len(t, config.RateLimits, 1) {
+		assert.Equal(t, "aggregator", config.RateLimits[0].Service)
+		assert.Equal(t, 2.5, *config.RateLimits[0].FillRate)
+		assert.Nil(t, config.RateLimits[0].BucketSize)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, validateConfig(crcConfig{}))
+	assert.NoError(t, validateConfig(parseConfig(t, `
+proxy_url          = "socks5://proxy:1080"
+ignore_error_codes = [404]
+tracing_endpoint   = "localhost:4317"
+
+rate_limit "aggregator" {
+  fill_rate   = 1
+  bucket_size = 1
+}
+`)))
+
+	tests := []struct {
+		name   string
+		config string
+		errors []string
+	}{
+		{"unknown replay mode", `replay_mode = "rewind"`, []string{"'replay_mode' must be one of"}},
+		{"invalid max attempts", `max_attempts = 0`, []string{"'max_attempts' must be at least 1"}},
+		{"invalid retry delay", `retry_base_delay = "soon"`, []string{"'retry_base_delay' is not a valid duration"}},
+		{"negative cache TTL", `
+cache_dir = "/tmp"
+cache_ttl = "-1h"
+`, []string{"'cache_ttl' must be a positive duration"}},
+		{"invalid max concurrency", `max_concurrency = 0`, []string{"'max_concurrency' must be at least 1"}},
+		{"invalid timeout", `timeout = "forever"`, []string{"'timeout' must be a positive duration"}},
+		{"invalid tracing endpoint", `tracing_endpoint = "http://localhost:4317"`, []string{"'tracing_endpoint' must be the host:port address"}},
+		{"unknown proxy scheme", `proxy_url = "ftp://proxy:21"`, []string{"'proxy_url' must be an absolute URL using the http or https or socks5 scheme"}},
+		{"proxy without host", `proxy_url = "http:///"`, []string{"'proxy_url' must have a host"}},
+		{"invalid error code", `ignore_error_codes = [404, 4040]`, []string{"'ignore_error_codes' must only contain HTTP status codes, between 100 and 599, got 4040"}},
+		{"invalid rate limit", `
+rate_limit "aggregator" {
+  fill_rate   = 0
+  bucket_size = 0
+}
+`, []string{"'fill_rate' of the rate_limit \"aggregator\" block must be positive", "'bucket_size' of the rate_limit \"aggregator\" block must be at least 1"}},
+		{"duplicate rate limit", `
+rate_limit "aggregator" {
+}
+rate_limit "aggregator" {
+}
+`, []string{"the rate_limit \"aggregator\" block is set more than once"}},
+		{"several invalid options", `
+max_attempts = 0
+replay_mode  = "rewind"
+`, []string{"'max_attempts' must be at least 1", "'replay_mode' must be one of"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(parseConfig(t, tt.config))
+			if assert.Error(t, err) {
+				for _, message := range tt.errors {
+					assert.Contains(t, err.Error(), message)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeSettings(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		expected string
+	}{
+		{"https://console.redhat.com", "https://console.redhat.com/"},
+		{"https://console.redhat.com/", "https://console.redhat.com/"},
+		{"HTTPS://console.redhat.com", "https://console.redhat.com/"},
+		{"https://gateway.example.com//crc//", "https://gateway.example.com/crc/"},
+		{"https://gateway.example.com/crc", "https://gateway.example.com/crc/"},
+		{"http://localhost:8080", "http://localhost:8080/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			settings := connectionSettings{BaseURL: tt.baseURL, TokenURL: DefaultOCMTokenURL}
+			assert.NoError(t, settings.normalize(crcConfig{}))
+			assert.Equal(t, tt.expected, settings.BaseURL)
+			assert.Equal(t, DefaultOCMTokenURL, settings.TokenURL)
+		})
+	}
+}
+
+func TestNormalizeInvalidSettings(t *testing.T) {
+	allowInsecure := true
+	tests := []struct {
+		name     string
+		settings connectionSettings
+		config   crcConfig
+		err      string
+	}{
+		{"base URL without scheme", connectionSettings{BaseURL: "console.redhat.com", TokenURL: DefaultOCMTokenURL}, crcConfig{},
+			"'base_url' must be an absolute URL using the http or https scheme"},
+		{"base URL with unknown scheme", connectionSettings{BaseURL: "ftp://console.redhat.com", TokenURL: DefaultOCMTokenURL}, crcConfig{},
+			"'base_url' must be an absolute URL using the http or https scheme"},
+		{"base URL without host", connectionSettings{BaseURL: "https:///api", TokenURL: DefaultOCMTokenURL}, crcConfig{},
+			"'base_url' must have a host"},
+		{"base URL with query", connectionSettings{BaseURL: "https://console.redhat.com/?org=1", TokenURL: DefaultOCMTokenURL}, crcConfig{},
+			"'base_url' must not have a query or a fragment"},
+		{"unparsable base URL", connectionSettings{BaseURL: "https://console.redhat.com:port/", TokenURL: DefaultOCMTokenURL}, crcConfig{},
+			"'base_url' is not a valid URL"},
+		{"insecure token URL", connectionSettings{BaseURL: "https://console.redhat.com/", TokenURL: "http://sso.redhat.com/token"}, crcConfig{},
+			"'token_url' must use https to keep the credentials secret"},
+		{"token URL with unknown scheme", connectionSettings{BaseURL: "https://console.redhat.com/", TokenURL: "ftp://sso.redhat.com/token"}, crcConfig{AllowInsecureTokenURL: &allowInsecure},
+			"'token_url' must be an absolute URL using the https or http scheme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.normalize(tt.config)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+
+	settings := connectionSettings{BaseURL: "https://console.redhat.com/", TokenURL: "http://localhost:8080/token"}
+	assert.NoError(t, settings.normalize(crcConfig{AllowInsecureTokenURL: &allowInsecure}))
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := loadConfig(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, crcConfig{}, config)
+
+	baseURL := "https://console.redhat.com/"
+	config, err = loadConfig(&plugin.Connection{Name: "crc", Config: crcConfig{BaseUrl: &baseURL}})
+	assert.NoError(t, err)
+	assert.Equal(t, baseURL, *config.BaseUrl)
+
+	connection := &plugin.Connection{Name: "crc", Config: "base_url"}
+	_, err = loadConfig(connection)
+	assert.EqualError(t, err, `the configuration of the connection "crc" has the unexpected type string, check that it is a connection of the crc plugin`)
+	assert.NotPanics(t, func() { GetConfig(connection) })
+	_, err = LoadValidConfig(connection)
+	assert.ErrorContains(t, err, "unexpected type string")
+
+	maxAttempts := 0
+	_, err = LoadValidConfig(&plugin.Connection{Name: "crc", Config: &crcConfig{MaxAttempts: &maxAttempts}})
+	assert.ErrorContains(t, err, `invalid configuration of the connection "crc"`)
+	assert.ErrorContains(t, err, "'max_attempts' must be at least 1")
+}
+
+func TestLoadConfigExpandsHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	config := parseConfig(t, `
+cache_dir        = "~/.cache/crc"
+cassette_dir     = "~/cassettes"
+ca_bundle_path   = "~/certs/ca.pem"
+client_cert_path = "~/certs/client.pem"
+client_key_path  = "/etc/crc/client.key"
+ocm_config_path  = "~/.config/ocm/ocm.json"
+openapi_paths    = ["~/openapi/*.json", "openapi/~/spec.json"]
+`)
+	loaded, err := loadConfig(&plugin.Connection{Name: "crc", Config: &config})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".cache", "crc"), *loaded.CacheDir)
+	assert.Equal(t, filepath.Join(home, "cassettes"), *loaded.CassetteDir)
+	assert.Equal(t, filepath.Join(home, "certs", "ca.pem"), *loaded.CABundlePath)
+	assert.Equal(t, filepath.Join(home, "certs", "client.pem"), *loaded.ClientCertPath)
+	assert.Equal(t, "/etc/crc/client.key", *loaded.ClientKeyPath)
+	assert.Equal(t, filepath.Join(home, ".config", "ocm", "ocm.json"), *loaded.OCMConfigPath)
+	assert.Equal(t, []string{filepath.Join(home, "openapi", "*.json"), "openapi/~/spec.json"}, loaded.OpenAPIPaths)
+
+	// the configuration of the connection is left as written
+	assert.Equal(t, "~/.cache/crc", *config.CacheDir)
+	assert.Equal(t, "~/openapi/*.json", config.OpenAPIPaths[0])
+}
@@ -0,0 +1,385 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// DefaultCacheTTL is how long the cached responses are served without
+// revalidation when 'cache_ttl' is not set
+const DefaultCacheTTL = 10 * time.Minute
+
+// CacheModeColumn is the optional qual controlling the disk cache for a query
+const CacheModeColumn = "cache_mode"
+
+// The values of the cache_mode qual
+const (
+	// CacheModeUse serves fresh cached responses and revalidates stale ones
+	CacheModeUse = "use"
+	// CacheModeRefresh ignores the cached responses but caches the new ones
+	CacheModeRefresh = "refresh"
+	// CacheModeBypass neither reads nor writes the cache
+	CacheModeBypass = "bypass"
+	// CacheModeClear deletes the cached responses of the connection, then refreshes them
+	CacheModeClear = "clear"
+)
+
+// diskCacheEntry is a cached response. Its file holds this header as a JSON
+// line followed by the raw body, so that neither the requests filling the
+// cache nor the ones served from it hold the body in memory. The entry was
+// stored, or last revalidated, at the modification time of the file.
+type diskCacheEntry struct {
+	Endpoint     string      `json:"endpoint"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Header       http.Header `json:"header"`
+
+	storedAt time.Time
+	body     io.ReadCloser
+}
+
+// response rebuilds the cached response, whose body is read from the file
+func (e *diskCacheEntry) response() *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          e.body,
+		ContentLength: -1,
+	}
+}
+
+// diskCache stores the responses of the GET requests of a connection in a
+// directory, so that they survive Steampipe sessions. It is resolved once
+// per connection client, see getConsoleDotClient.
+type diskCache struct {
+	dir        string
+	defaultTTL time.Duration
+	ttls       map[string]time.Duration
+
+	// connection is the name of the connection whose responses are cached
+	connection string
+	// identity is the fingerprint of the client of the connection, so that
+	// the responses cached for other credentials or organizations are not
+	// served, see clientCacheKey
+	identity string
+}
+
+// diskCacheFromConfig returns the disk cache of the connection, or nil if 'cache_dir' is not set
+func diskCacheFromConfig(config crcConfig) (*diskCache, error) {
+	if config.CacheDir == nil || *config.CacheDir == "" {
+		return nil, nil
+	}
+
+	cache := &diskCache{dir: *config.CacheDir, defaultTTL: DefaultCacheTTL, ttls: map[string]time.Duration{}}
+	if config.CacheTTL != nil {
+		ttl, err := time.ParseDuration(*config.CacheTTL)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("'cache_ttl' must be a positive duration, got %q", *config.CacheTTL)
+		}
+		cache.defaultTTL = ttl
+	}
+	if config.CacheTTLs != nil {
+		for service, value := range *config.CacheTTLs {
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return nil, fmt.Errorf("'cache_ttls' of service %q must be a positive duration, got %q", service, value)
+			}
+			cache.ttls[service] = ttl
+		}
+	}
+
+	return cache, nil
+}
+
+// forClient returns the cache of the connection for the client with the identity
+func (c *diskCache) forClient(connection, identity string) *diskCache {
+	if c == nil {
+		return nil
+	}
+	cache := *c
+	cache.connection, cache.identity = connection, identity
+	return &cache
+}
+
+// ttl returns how long the responses of the service are served without revalidation
+func (c *diskCache) ttl(service string) time.Duration {
+	if ttl, ok := c.ttls[service]; ok {
+		return ttl
+	}
+	return c.defaultTTL
+}
+
+// connectionDir returns the directory holding the cached responses of the connection
+func (c *diskCache) connectionDir() string {
+	return filepath.Join(c.dir, unsafeFileNameChars.ReplaceAllString(c.connection, "_"))
+}
+
+// path returns the file caching the response of the URL for the client,
+// whose query parameters may be in any order
+func (c *diskCache) path(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		u.RawQuery = u.Query().Encode()
+		rawURL = u.String()
+	}
+	hash := sha256.Sum256([]byte(c.identity + "\n" + rawURL))
+	return filepath.Join(c.connectionDir(), fmt.Sprintf("%x.cache", hash[:16]))
+}
+
+// read opens the cached entry, or returns nil if there is none. The caller
+// must close the body of the entry unless it serves its response.
+func (c *diskCache) read(path string) *diskCacheEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil
+	}
+
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	var entry diskCacheEntry
+	if err == nil {
+		err = json.Unmarshal(line, &entry)
+	}
+	if err != nil {
+		log.Printf("[WARN] ignoring the corrupted cache entry %s: %v", path, err)
+		file.Close()
+		return nil
+	}
+	entry.storedAt = info.ModTime()
+	entry.body = struct {
+		io.Reader
+		io.Closer
+	}{reader, file}
+	return &entry
+}
+
+// touch marks the entry as stored now, once revalidated
+func (c *diskCache) touch(path string) {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		log.Printf("[WARN] error updating the cache entry %s: %v", path, err)
+	}
+}
+
+// write returns the response whose body is copied into the entry at the path
+// as it is read, see cacheBody
+func (c *diskCache) write(path string, rawURL string, resp *http.Response) *http.Response {
+	header, err := json.Marshal(&diskCacheEntry{
+		Endpoint:     rawURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Header:       resp.Header,
+	})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	var tmp *os.File
+	if err == nil {
+		tmp, err = os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	}
+	if err == nil {
+		_, err = tmp.Write(append(header, '\n'))
+	}
+	if err != nil {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+		log.Printf("[WARN] error writing the cache entry %s: %v", path, err)
+		return resp
+	}
+
+	resp.Body = &cacheBody{ReadCloser: resp.Body, tmp: tmp, path: path}
+	return resp
+}
+
+// do serves the GET request of the URL from the cache according to the mode,
+// calling send with the revalidation headers, if any, to request it
+func (c *diskCache) do(service, rawURL, mode string, send func(header http.Header) (*http.Response, error)) (*http.Response, error) {
+	if mode == CacheModeBypass {
+		return send(nil)
+	}
+
+	path := c.path(rawURL)
+	var entry *diskCacheEntry
+	if mode == CacheModeUse {
+		entry = c.read(path)
+	}
+	if entry != nil && time.Since(entry.storedAt) < c.ttl(service) {
+		return entry.response(), nil
+	}
+
+	header := http.Header{}
+	if entry != nil && entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+	if entry != nil && entry.LastModified != "" {
+		header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	resp, err := send(header)
+	if err == nil && resp.StatusCode == http.StatusNotModified && entry != nil {
+		resp.Body.Close()
+		c.touch(path)
+		return entry.response(), nil
+	}
+	if entry != nil {
+		entry.body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.write(path, rawURL, resp), nil
+}
+
+// cacheDrainLimit is how much of the body is still read when it is closed
+// before its end, e.g. the whitespace following a JSON document, so that the
+// response is cached. A larger rest means that the reader stopped early.
+const cacheDrainLimit = 4 << 10
+
+// cacheBody copies the body of a response into a temporary file while the
+// caller reads it, and renames the file to the cache entry once the body is
+// completely read. A body not read to its end is not cached.
+type cacheBody struct {
+	io.ReadCloser
+	tmp  *os.File
+	path string
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.tmp != nil {
+		if _, writeErr := b.tmp.Write(p[:n]); writeErr != nil {
+			log.Printf("[WARN] error writing the cache entry %s: %v", b.path, writeErr)
+			b.discard()
+		}
+	}
+	switch {
+	case err == io.EOF:
+		b.commit()
+	case err != nil:
+		b.discard()
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	if b.tmp != nil {
+		if _, err := io.CopyN(io.Discard, b, cacheDrainLimit); err != io.EOF {
+			b.discard()
+		}
+	}
+	return b.ReadCloser.Close()
+}
+
+// commit renames the temporary file to the cache entry
+func (b *cacheBody) commit() {
+	if b.tmp == nil {
+		return
+	}
+	tmp := b.tmp
+	b.tmp = nil
+	err := tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), b.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[WARN] error writing the cache entry %s: %v", b.path, err)
+	}
+}
+
+// discard deletes the temporary file of an incomplete body
+func (b *cacheBody) discard() {
+	if b.tmp == nil {
+		return
+	}
+	b.tmp.Close()
+	os.Remove(b.tmp.Name())
+	b.tmp = nil
+}
+
+// ClearDiskCache deletes the cached responses of the connection if the
+// query asks for it with cache_mode = 'clear'. The List hydrates call it
+// before their first request, so that the responses cached by the query
+// itself are kept: its requests refresh them. Each value of an IN list has its
+// own List hydrate, clearing the cache when it starts.
+func ClearDiskCache(ctx context.Context, d *plugin.QueryData) error {
+	client, err := getConsoleDotClient(ctx, d)
+	if err != nil || client.cache == nil {
+		return err
+	}
+	mode, err := cacheMode(d)
+	if err != nil || mode != CacheModeClear {
+		return err
+	}
+
+	if err := os.RemoveAll(client.cache.connectionDir()); err != nil {
+		return fmt.Errorf("error clearing the cache: %v", err)
+	}
+	return nil
+}
+
+// noDiskCacheKey is the context key of WithoutDiskCache
+type noDiskCacheKey struct{}
+
+// WithoutDiskCache returns a context whose API requests neither read nor
+// write the disk cache, whatever the cache_mode of the query, e.g. for the
+// requests checking the access of the connection
+func WithoutDiskCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDiskCacheKey{}, true)
+}
+
+// isDiskCacheDisabled reports whether the context comes from WithoutDiskCache
+func isDiskCacheDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(noDiskCacheKey{}).(bool)
+	return disabled
+}
+
+// cacheMode returns the cache mode requested by the cache_mode qual of the query
+func cacheMode(d *plugin.QueryData) (string, error) {
+	switch mode := d.EqualsQualString(CacheModeColumn); mode {
+	case "":
+		return CacheModeUse, nil
+	case CacheModeUse, CacheModeRefresh, CacheModeBypass, CacheModeClear:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("'%s' must be one of %q, %q, %q or %q, got %q", CacheModeColumn, CacheModeUse, CacheModeRefresh, CacheModeBypass, CacheModeClear, mode)
+	}
+}
+
+// doCachedAPIRequest sends the GET request through the disk cache of the
+// connection according to the cache_mode qual of the query
+func doCachedAPIRequest(d *plugin.QueryData, cache *diskCache, rawURL string, send func(header http.Header) (*http.Response, error)) (*http.Response, error) {
+	mode, err := cacheMode(d)
+	if err != nil {
+		return nil, err
+	}
+	// the cache was cleared before the first request of the query, see ClearDiskCache
+	if mode == CacheModeClear {
+		mode = CacheModeRefresh
+	}
+
+	return cache.do(tableService(d), rawURL, mode, send)
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSender returns a send function answering with the body, or with a 304
+// when the request revalidates the ETag, and recording the request headers
+func fakeSender(body, etag string, sent *[]http.Header) func(http.Header) (*http.Response, error) {
+	return func(header http.Header) (*http.Response, error) {
+		*sent = append(*sent, header)
+		if etag != "" && header.Get("If-None-Match") == etag {
+			return &http.Response{StatusCode: http.StatusNotModified, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		respHeader := http.Header{}
+		if etag != "" {
+			respHeader.Set("ETag", etag)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: respHeader, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+}
+
+func TestDiskCacheServesFreshResponses(t *testing.T) {
+	dir := t.TempDir()
+	config, err := diskCacheFromConfig(crcConfig{CacheDir: &dir})
+	assert.NoError(t, err)
+	cache := config.forClient("crc", "client")
+
+	var sent []http.Header
+	url := "https://console.redhat.com/api/clusters?offset=0&limit=10"
+	for i := 0; i < 2; i++ {
+		resp, err := cache.do(ServiceAggregator, url, CacheModeUse, fakeSender(`{"data": []}`, "", &sent))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"data": []}`, readBody(t, resp))
+	}
+	assert.Len(t, sent, 1)
+
+	// the query parameters may be in any order, but neither the connections
+	// nor the clients of other credentials share their cache
+	resp, err := cache.do(ServiceAggregator, "https://console.redhat.com/api/clusters?limit=10&offset=0", CacheModeUse, fakeSender("", "", &sent))
+	assert.NoError(t, err)
+	readBody(t, resp)
+	assert.Len(t, sent, 1)
+	resp, err = config.forClient("other", "client").do(ServiceAggregator, url, CacheModeUse, fakeSender("", "", &sent))
+	assert.NoError(t, err)
+	readBody(t, resp)
+	assert.Len(t, sent, 2)
+	resp, err = config.forClient("crc", "other client").do(ServiceAggregator, url, CacheModeUse, fakeSender("", "", &sent))
+	assert.NoError(t, err)
+	readBody(t, resp)
+	assert.Len(t, sent, 3)
+}
+
+func TestDiskCacheRevalidatesStaleResponses(t *testing.T) {
+	dir, ttl, ttls := t.TempDir(), "1h", map[string]string{ServiceOCPVulnerability: "0s"}
+	config, err := diskCacheFromConfig(crcConfig{CacheDir: &dir, CacheTTL: &ttl, CacheTTLs: &ttls})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, config.ttl(ServiceAggregator))
+	cache := config.forClient("crc", "client")
+
+	var sent []http.Header
+	url := "https://console.redhat.com/api/ocp-vulnerability/v1/cves"
+	resp, err := cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender(`{"data": [1]}`, `"v1"`, &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"data": [1]}`, readBody(t, resp))
+
+	// the response is stale straight away, but still valid according to its ETag
+	resp, err = cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender(`{"data": [2]}`, `"v1"`, &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"data": [1]}`, readBody(t, resp))
+	assert.Equal(t, `"v1"`, sent[1].Get("If-None-Match"))
+
+	resp, err = cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender(`{"data": [2]}`, `"v2"`, &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"data": [2]}`, readBody(t, resp))
+}
+
+func TestDiskCacheModes(t *testing.T) {
+	dir := t.TempDir()
+	config, err := diskCacheFromConfig(crcConfig{CacheDir: &dir})
+	assert.NoError(t, err)
+	cache := config.forClient("crc", "client")
+
+	var sent []http.Header
+	url := "https://console.redhat.com/api/gathering/v1/gathering_rules"
+	resp, err := cache.do(ServiceGathering, url, CacheModeUse, fakeSender("1", "", &sent))
+	assert.NoError(t, err)
+	readBody(t, resp)
+
+	// bypassing neither reads nor writes the cache
+	resp, err = cache.do(ServiceGathering, url, CacheModeBypass, fakeSender("2", "", &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", readBody(t, resp))
+	assert.Nil(t, sent[1])
+
+	// refreshing skips the cached response but stores the new one
+	resp, err = cache.do(ServiceGathering, url, CacheModeRefresh, fakeSender("3", "", &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", readBody(t, resp))
+	resp, err = cache.do(ServiceGathering, url, CacheModeUse, fakeSender("4", "", &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", readBody(t, resp))
+	assert.Len(t, sent, 3)
+}
+
+func TestDiskCachePartialBodies(t *testing.T) {
+	dir := t.TempDir()
+	config, err := diskCacheFromConfig(crcConfig{CacheDir: &dir})
+	assert.NoError(t, err)
+	cache := config.forClient("crc", "client")
+
+	// a body closed before its end is not cached, unless only a few bytes are left
+	var sent []http.Header
+	url := "https://console.redhat.com/api/ocp-vulnerability/v1/clusters"
+	body := `{"data": [` + strings.Repeat(`{"id": "c"},`, 1000) + `{"id": "c"}]}`
+	resp, err := cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender(body, "", &sent))
+	assert.NoError(t, err)
+	_, err = io.ReadFull(resp.Body, make([]byte, 100))
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	entries, err := os.ReadDir(cache.connectionDir())
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	resp, err = cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender(body, "", &sent))
+	assert.NoError(t, err)
+	_, err = io.ReadFull(resp.Body, make([]byte, len(body)-10))
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+
+	resp, err = cache.do(ServiceOCPVulnerability, url, CacheModeUse, fakeSender("", "", &sent))
+	assert.NoError(t, err)
+	assert.Equal(t, body, readBody(t, resp))
+	assert.Len(t, sent, 2)
+}
+
+func TestDiskCacheConfigErrors(t *testing.T) {
+	cache, err := diskCacheFromConfig(crcConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+
+	dir, invalid := t.TempDir(), "forever"
+	_, err = diskCacheFromConfig(crcConfig{CacheDir: &dir, CacheTTL: &invalid})
+	assert.ErrorContains(t, err, "'cache_ttl'")
+
+	_, err = diskCacheFromConfig(crcConfig{CacheDir: &dir, CacheTTLs: &map[string]string{ServiceAggregator: invalid}})
+	assert.ErrorContains(t, err, "'cache_ttls'")
+}
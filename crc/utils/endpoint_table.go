@@ -0,0 +1,425 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
+)
+
+// EndpointTable declares a table whose rows are the items returned by an
+// endpoint of a console.redhat.com service. Its List hydrate is generated
+// with the shared pagination, error handling, logging and tracing:
+//
+//	func TableClusterCVEsV1(_ context.Context) *plugin.Table {
+//		return utils.EndpointTable[vulnerabilitiesV1ClusterCVE]{
+//			Name:     V1ClusterCVEsTableName,
+//			Service:  utils.ServiceOCPVulnerability,
+//			Endpoint: "api/ocp-vulnerability/v1/clusters/{cluster_id}/cves",
+//			PageSize: utils.DefaultPageSize,
+//			Columns:  []utils.ColumnSpec{...},
+//		}.Table()
+//	}
+type EndpointTable[T any] struct {
+	Name        string
+	Description string
+	// Service is the console.redhat.com service of the endpoint, selecting its rate limiters
+	Service string
+
+	// Endpoint is the template of the endpoint, relative to the base URL. Each
+	// {qualifier} of its path is replaced by the value of the required key
+	// column of the same name, e.g. "api/ocp-vulnerability/v1/clusters/{cluster_id}/cves".
+	Endpoint string
+	// ItemsPath is the path of the array of items in the response envelope.
+	// Defaults to ["data"].
+	ItemsPath []string
+	// Document decodes the whole response as a single item instead of
+	// streaming the items of an array, e.g. for the endpoints returning a
+	// single document
+	Document bool
+	// PageSize is the limit requested per page, see Paginate. Zero only
+	// follows the links returned by the API, if any.
+	PageSize int
+	// Timeout bounds the requests to the endpoint, unless the connection
+	// overrides it. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// FanOut makes a qualifier of the endpoint optional: when the query
+	// doesn't give it, its values are listed from another endpoint and this
+	// endpoint is requested for each of them, see FanOut
+	FanOut *FanOutSpec
+
+	// Rows converts each item into the rows of the table, e.g. to flatten the
+	// arrays of a document. Defaults to a row per item.
+	Rows func(item T) []interface{}
+	// Columns are the columns of the table, without the common columns
+	Columns []ColumnSpec
+}
+
+// ColumnSpec declares a column of an EndpointTable
+type ColumnSpec struct {
+	Name        string
+	Type        proto.ColumnType
+	Description string
+	// Field is the path of the field of the row the column is read from, e.g.
+	// "ClusterID" or "ExtraData.ErrorKey". Defaults to the name of the column,
+	// for the rows which are maps. The columns named after a qualifier of the
+	// endpoint are read from the value of the qualifier instead.
+	Field string
+	// QueryParam makes the column an optional key column, whose value is sent
+	// as this query parameter of the endpoint. The column falls back to the
+	// value of the qual for the items without the field.
+	QueryParam string
+}
+
+// FanOutSpec declares where the values of an optional qualifier of an
+// EndpointTable are listed from, e.g. the clusters of the organization for
+// {cluster_id}. The endpoint must belong to the service of the table, whose
+// rate limiters apply.
+type FanOutSpec struct {
+	// Qualifier is the qualifier of the endpoint of the table, e.g. "cluster_id"
+	Qualifier string
+	// Endpoint is the endpoint listing the values, e.g. "api/ocp-vulnerability/v1/clusters"
+	Endpoint string
+	// ItemsPath is the path of the array of items in the response envelope.
+	// Defaults to ["data"].
+	ItemsPath []string
+	// Field is the field of the items holding the value, e.g. "id"
+	Field string
+	// PageSize is the limit requested per page, see Paginate
+	PageSize int
+	// Timeout bounds the requests to the endpoint, unless the connection
+	// overrides it. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// EndpointRow is a row of an EndpointTable: an item of the response along
+// with the qualifiers of the endpoint it was requested with
+type EndpointRow struct {
+	Item  interface{}
+	Quals map[string]string
+}
+
+// endpointQualifiers match the {qualifier} placeholders of the endpoint templates
+var endpointQualifiers = regexp.MustCompile(`{([a-z0-9_]+)}`)
+
+// Table returns the table, with the common columns and key columns
+func (t EndpointTable[T]) Table() *plugin.Table {
+	qualifiers := t.qualifiers()
+	isQualifier := map[string]bool{}
+	var keyColumns plugin.KeyColumnSlice
+	for _, qualifier := range qualifiers {
+		isQualifier[qualifier] = true
+		require := plugin.Required
+		if t.isFannedOut(qualifier) {
+			require = plugin.Optional
+		}
+		keyColumns = append(keyColumns, &plugin.KeyColumn{Name: qualifier, Require: require})
+	}
+
+	columns := make([]*plugin.Column, 0, len(t.Columns))
+	for _, spec := range t.Columns {
+		fields := []string{"Item." + spec.Field}
+		if spec.Field == "" {
+			fields = []string{"Item." + spec.Name}
+		}
+		if isQualifier[spec.Name] {
+			fields = []string{"Quals." + spec.Name}
+		} else if spec.QueryParam != "" {
+			fields = append(fields, "Quals."+spec.Name)
+			keyColumns = append(keyColumns, &plugin.KeyColumn{Name: spec.Name, Require: plugin.Optional})
+		}
+		columns = append(columns, &plugin.Column{
+			Name:        spec.Name,
+			Type:        spec.Type,
+			Description: spec.Description,
+			Transform:   transform.FromField(fields...),
+		})
+	}
+
+	return &plugin.Table{
+		Name:        t.Name,
+		Description: t.Description,
+		Tags:        ServiceTags(t.Service),
+		List: &plugin.ListConfig{
+			Hydrate:      t.list,
+			IgnoreConfig: IgnoreConfig(),
+			KeyColumns:   WithCommonKeyColumns(keyColumns),
+		},
+		Columns: WithCommonColumns(columns),
+	}
+}
+
+// qualifiers returns the qualifiers of the endpoint, in order
+func (t EndpointTable[T]) qualifiers() []string {
+	var qualifiers []string
+	for _, match := range endpointQualifiers.FindAllStringSubmatch(t.Endpoint, -1) {
+		qualifiers = append(qualifiers, match[1])
+	}
+	return qualifiers
+}
+
+// isFannedOut reports whether the qualifier is optional, fanning out to its values
+func (t EndpointTable[T]) isFannedOut(qualifier string) bool {
+	return t.FanOut != nil && t.FanOut.Qualifier == qualifier
+}
+
+// endpoint returns the endpoint requested with the values of its qualifiers,
+// escaped into the path, and of its query parameters
+func (t EndpointTable[T]) endpoint(quals map[string]string) string {
+	endpoint := endpointQualifiers.ReplaceAllStringFunc(t.Endpoint, func(placeholder string) string {
+		return url.PathEscape(quals[placeholder[1:len(placeholder)-1]])
+	})
+
+	query := url.Values{}
+	for _, spec := range t.Columns {
+		if value, ok := quals[spec.Name]; ok && spec.QueryParam != "" {
+			query.Set(spec.QueryParam, value)
+		}
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+// quals returns the quals of each request to send to the endpoint: the
+// values of its qualifiers and of its query parameters in the query, one
+// request per distinct combination when they are given several values.
+// Steampipe already requests each value of a single IN list or = ANY array
+// with its own copy of the QueryData, so the quals only have several values
+// when there are several lists.
+func (t EndpointTable[T]) quals(d *plugin.QueryData) ([]map[string]string, error) {
+	combinations := []map[string]string{{}}
+	addValues := func(column string, values []string) {
+		var next []map[string]string
+		for _, quals := range combinations {
+			for _, value := range values {
+				next = append(next, withQual(quals, column, value))
+			}
+		}
+		combinations = next
+	}
+
+	for _, qualifier := range t.qualifiers() {
+		values, ok := equalsValues(d, qualifier)
+		if !ok {
+			if t.isFannedOut(qualifier) {
+				continue
+			}
+			return nil, fmt.Errorf("you must specify the %s", qualifier)
+		}
+		addValues(qualifier, values)
+	}
+	for _, spec := range t.Columns {
+		if spec.QueryParam == "" {
+			continue
+		}
+		if values, ok := equalsValues(d, spec.Name); ok {
+			addValues(spec.Name, values)
+		}
+	}
+	return combinations, nil
+}
+
+// equalsValues returns the distinct values of the equality quals of the
+// column, of its IN lists and = ANY arrays too, and whether it has any. The
+// values must be in all of them, e.g. none for cluster_id = 'a' AND
+// cluster_id = 'b'.
+func equalsValues(d *plugin.QueryData, column string) ([]string, bool) {
+	columnQuals, ok := d.Quals[column]
+	if !ok {
+		return nil, false
+	}
+
+	var values []string
+	found := false
+	for _, qual := range columnQuals.Quals {
+		if qual.Operator != "=" {
+			continue
+		}
+		qualValues := []*proto.QualValue{qual.Value}
+		if list := qual.Value.GetListValue(); list != nil {
+			qualValues = list.Values
+		}
+
+		var distinct []string
+		seen := map[string]bool{}
+		for _, qualValue := range qualValues {
+			value := fmt.Sprint(grpc.GetQualValue(qualValue))
+			if value != "" && !seen[value] && (!found || slices.Contains(values, value)) {
+				seen[value] = true
+				distinct = append(distinct, value)
+			}
+		}
+		values, found = distinct, true
+	}
+	return values, found
+}
+
+// withQual returns a copy of the quals with the value of one more qual
+func withQual(quals map[string]string, name, value string) map[string]string {
+	res := map[string]string{name: value}
+	for name, value := range quals {
+		res[name] = value
+	}
+	return res
+}
+
+// timeout returns the default timeout of the requests to the endpoint
+func (t EndpointTable[T]) timeout() time.Duration {
+	if t.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return t.Timeout
+}
+
+// rows returns the rows of the item
+func (t EndpointTable[T]) rows(item T, quals map[string]string) []EndpointRow {
+	items := []interface{}{item}
+	if t.Rows != nil {
+		items = t.Rows(item)
+	}
+	rows := make([]EndpointRow, len(items))
+	for i, item := range items {
+		rows[i] = EndpointRow{Item: item, Quals: quals}
+	}
+	return rows
+}
+
+// list is the generated List hydrate, streaming the rows of every page of
+// the endpoint, requested once per combination of the values of the quals
+func (t EndpointTable[T]) list(ctx context.Context, d *plugin.QueryData, _ *plugin.HydrateData) (interface{}, error) {
+	ctx, span := StartHydrateSpan(ctx, d, "list "+t.Name)
+	defer span.End()
+
+	if err := ClearDiskCache(ctx, d); err != nil {
+		LogErrorUsingSteampipeLogger(ctx, t.Name, "query_error", err)
+		return nil, err
+	}
+
+	combinations, err := t.quals(d)
+	if err != nil {
+		LogErrorUsingSteampipeLogger(ctx, t.Name, "query_error", err)
+		return nil, err
+	}
+
+	// the fanned out qualifier is missing from every combination or from none
+	if t.FanOut != nil && len(combinations) > 0 {
+		if _, ok := combinations[0][t.FanOut.Qualifier]; !ok {
+			if combinations, err = t.fanOut(ctx, d, combinations); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(combinations) == 1 {
+		return nil, t.listEndpoint(ctx, d, combinations[0])
+	}
+	return nil, t.listEach(ctx, d, combinations)
+}
+
+// fanOut returns the combinations of the quals with each value of the
+// fanned out qualifier
+func (t EndpointTable[T]) fanOut(ctx context.Context, d *plugin.QueryData, combinations []map[string]string) ([]map[string]string, error) {
+	values, err := t.fanOutValues(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []map[string]string
+	for _, quals := range combinations {
+		for _, value := range values {
+			res = append(res, withQual(quals, t.FanOut.Qualifier, value))
+		}
+	}
+	return res, nil
+}
+
+// listEach streams the rows of the endpoint requested with each of the
+// combinations of the quals, with a bounded worker pool
+func (t EndpointTable[T]) listEach(ctx context.Context, d *plugin.QueryData, combinations []map[string]string) error {
+	endpoints := make([]string, 0, len(combinations))
+	quals := map[string]map[string]string{}
+	for _, combination := range combinations {
+		endpoint := t.endpoint(combination)
+		if _, ok := quals[endpoint]; !ok {
+			endpoints = append(endpoints, endpoint)
+			quals[endpoint] = combination
+		}
+	}
+
+	return FanOut(ctx, d, endpoints, func(ctx context.Context, endpoint string) error {
+		err := t.listEndpoint(ctx, d, quals[endpoint])
+		// e.g. a cluster without any report has no rows, like when it is queried alone
+		if ShouldIgnoreError(ctx, d, nil, err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// fanOutValues returns the values of the fanned out qualifier, e.g. the IDs
+// of the clusters of the organization
+func (t EndpointTable[T]) fanOutValues(ctx context.Context, d *plugin.QueryData) ([]string, error) {
+	spec := t.FanOut
+	itemsPath := spec.ItemsPath
+	if itemsPath == nil {
+		itemsPath = []string{"data"}
+	}
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	var values []string
+	seen := map[string]bool{}
+	err := Paginate(withEndpointTemplate(ctx, spec.Endpoint), d, t.Name, spec.Endpoint, spec.PageSize, timeout, func(body io.ReadCloser) (*Page, error) {
+		// every value is needed whatever the number of rows remaining
+		return streamArray(body, itemsPath, func(item map[string]interface{}) {
+			if value, ok := item[spec.Field].(string); ok && value != "" && !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}, nil, func() bool { return false })
+	})
+	return values, err
+}
+
+// listEndpoint streams the rows of every page of the endpoint requested with the quals
+func (t EndpointTable[T]) listEndpoint(ctx context.Context, d *plugin.QueryData, quals map[string]string) error {
+	streamRows := func(item T) {
+		for _, row := range t.rows(item, quals) {
+			StreamListItem(ctx, d, row)
+			if RowsRemaining(ctx, d) == 0 {
+				return
+			}
+		}
+	}
+
+	return Paginate(withEndpointTemplate(ctx, t.Endpoint), d, t.Name, t.endpoint(quals), t.PageSize, t.timeout(), func(body io.ReadCloser) (*Page, error) {
+		if t.Document {
+			var document T
+			if err := DecodeJSON(d, body, &document); err != nil {
+				return nil, err
+			}
+			streamRows(document)
+			// the documents are returned in a single page
+			return nil, nil
+		}
+
+		itemsPath := t.ItemsPath
+		if itemsPath == nil {
+			itemsPath = []string{"data"}
+		}
+		return StreamArray(ctx, d, body, itemsPath, streamRows)
+	})
+}
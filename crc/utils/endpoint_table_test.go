@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/quals"
+)
+
+type endpointTestItem struct {
+	Name string `json:"name"`
+}
+
+var endpointTestTable = EndpointTable[endpointTestItem]{
+	Name:     "crc_test",
+	Service:  ServiceOCPVulnerability,
+	Endpoint: "api/ocp-vulnerability/v1/clusters/{cluster_id}/cves/{cve_name}/images",
+	Columns: []ColumnSpec{
+		{Name: "cluster_id", Type: proto.ColumnType_STRING},
+		{Name: "name", Type: proto.ColumnType_STRING, Field: "Name"},
+		{Name: "version", Type: proto.ColumnType_STRING},
+	},
+}
+
+func TestEndpointTableEndpoint(t *testing.T) {
+	assert.Equal(t, []string{"cluster_id", "cve_name"}, endpointTestTable.qualifiers())
+	assert.Equal(t, "api/ocp-vulnerability/v1/clusters/42/cves/CVE-2023-44487/images",
+		endpointTestTable.endpoint(map[string]string{"cluster_id": "42", "cve_name": "CVE-2023-44487"}))
+
+	// the values of the qualifiers can't change the path
+	assert.Equal(t, "api/ocp-vulnerability/v1/clusters/..%2Fcves/cves/a%3Fb/images",
+		endpointTestTable.endpoint(map[string]string{"cluster_id": "../cves", "cve_name": "a?b"}))
+
+	assert.Nil(t, EndpointTable[endpointTestItem]{Endpoint: "api/ocp-vulnerability/v1/cves"}.qualifiers())
+
+	// the query parameters are sent when they have a qual
+	table := EndpointTable[endpointTestItem]{
+		Endpoint: "api/ocp-vulnerability/v1/clusters",
+		Columns: []ColumnSpec{
+			{Name: "search", Type: proto.ColumnType_STRING, QueryParam: "search"},
+			{Name: "known_exploit", Type: proto.ColumnType_BOOL, QueryParam: "knownExploit"},
+		},
+	}
+	assert.Equal(t, "api/ocp-vulnerability/v1/clusters", table.endpoint(map[string]string{}))
+	assert.Equal(t, "api/ocp-vulnerability/v1/clusters?knownExploit=true&search=prod+eu",
+		table.endpoint(map[string]string{"search": "prod eu", "known_exploit": "true"}))
+}
+
+func TestEndpointTableTable(t *testing.T) {
+	table := endpointTestTable.Table()
+	assert.Equal(t, "crc_test", table.Name)
+	assert.Equal(t, ServiceTags(ServiceOCPVulnerability), table.Tags)
+	assert.Nil(t, table.Get)
+	if assert.NotNil(t, table.List) {
+		var required []string
+		for _, column := range table.List.KeyColumns {
+			if column.Require == plugin.Required {
+				required = append(required, column.Name)
+			}
+		}
+		assert.Equal(t, []string{"cluster_id", "cve_name"}, required)
+	}
+
+	// the qualifiers are read from the row, the other columns from the item
+	fields := map[string]interface{}{}
+	for _, column := range table.Columns {
+		fields[column.Name] = column.Transform.Transforms[0].Param
+	}
+	assert.Equal(t, []string{"Quals.cluster_id"}, fields["cluster_id"])
+	assert.Equal(t, []string{"Item.Name"}, fields["name"])
+	assert.Equal(t, []string{"Item.version"}, fields["version"])
+	assert.Contains(t, fields, "org_id")
+
+	// the query parameters are optional key columns, read from the item or else from the qual
+	queryTable := endpointTestTable
+	queryTable.Columns = append([]ColumnSpec{{Name: "search", Type: proto.ColumnType_STRING, QueryParam: "search"}}, queryTable.Columns...)
+	table = queryTable.Table()
+	optional := map[string]bool{}
+	for _, column := range table.List.KeyColumns {
+		if column.Require == plugin.Optional {
+			optional[column.Name] = true
+		}
+	}
+	assert.True(t, optional["search"])
+	assert.Equal(t, []string{"Item.search", "Quals.search"}, table.Columns[0].Transform.Transforms[0].Param)
+
+	// the fanned out qualifier is optional
+	fanOutTable := endpointTestTable
+	fanOutTable.FanOut = &FanOutSpec{Qualifier: "cluster_id", Endpoint: "api/ocp-vulnerability/v1/clusters", Field: "id"}
+	table = fanOutTable.Table()
+	require := map[string]string{}
+	for _, column := range table.List.KeyColumns {
+		require[column.Name] = column.Require
+	}
+	assert.Equal(t, plugin.Optional, require["cluster_id"])
+	assert.Equal(t, plugin.Required, require["cve_name"])
+}
+
+// queryData returns the QueryData of a query with the key column quals,
+// each an equality or, for a []string, an IN list, as Steampipe passes them
+// to the List hydrate
+func queryData(columnQuals map[string][]interface{}) *plugin.QueryData {
+	keyColumnQuals := plugin.KeyColumnQualMap{}
+	for column, values := range columnQuals {
+		keyColumnQuals[column] = &plugin.KeyColumnQuals{Name: column}
+		for _, value := range values {
+			qualValue := &proto.QualValue{}
+			switch v := value.(type) {
+			case string:
+				qualValue.Value = &proto.QualValue_StringValue{StringValue: v}
+			case []string:
+				list := &proto.QualValueList{}
+				for _, item := range v {
+					list.Values = append(list.Values, &proto.QualValue{Value: &proto.QualValue_StringValue{StringValue: item}})
+				}
+				qualValue.Value = &proto.QualValue_ListValue{ListValue: list}
+			}
+			keyColumnQuals[column].Quals = append(keyColumnQuals[column].Quals, &quals.Qual{Column: column, Operator: "=", Value: qualValue})
+		}
+	}
+	return &plugin.QueryData{Quals: keyColumnQuals, EqualsQuals: keyColumnQuals.ToEqualsQualValueMap()}
+}
+
+func TestEndpointTableQuals(t *testing.T) {
+	table := endpointTestTable
+	table.Columns = append([]ColumnSpec{{Name: "search", Type: proto.ColumnType_STRING, QueryParam: "search"}}, table.Columns...)
+
+	// a request per distinct combination of the values, of the query
+	// parameters too, when several columns have an IN list
+	combinations, err := table.quals(queryData(map[string][]interface{}{
+		"cluster_id": {[]string{"a", "b", "a"}},
+		"cve_name":   {[]string{"CVE-1", "CVE-2"}},
+		"search":     {[]string{"prod", "stage"}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"cluster_id": "a", "cve_name": "CVE-1", "search": "prod"},
+		{"cluster_id": "a", "cve_name": "CVE-1", "search": "stage"},
+		{"cluster_id": "a", "cve_name": "CVE-2", "search": "prod"},
+		{"cluster_id": "a", "cve_name": "CVE-2", "search": "stage"},
+		{"cluster_id": "b", "cve_name": "CVE-1", "search": "prod"},
+		{"cluster_id": "b", "cve_name": "CVE-1", "search": "stage"},
+		{"cluster_id": "b", "cve_name": "CVE-2", "search": "prod"},
+		{"cluster_id": "b", "cve_name": "CVE-2", "search": "stage"},
+	}, combinations)
+
+	// a single request for the copy of the QueryData of each value of a single list
+	combinations, err = table.quals(queryData(map[string][]interface{}{
+		"cluster_id": {"a"},
+		"cve_name":   {"CVE-1"},
+		"search":     {"prod"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"cluster_id": "a", "cve_name": "CVE-1", "search": "prod"}}, combinations)
+
+	// the values must match every qual
+	combinations, err = table.quals(queryData(map[string][]interface{}{
+		"cluster_id": {"a", []string{"a", "b"}},
+		"cve_name":   {"CVE-1"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"cluster_id": "a", "cve_name": "CVE-1"}}, combinations)
+
+	combinations, err = table.quals(queryData(map[string][]interface{}{"cluster_id": {"a", "b"}, "cve_name": {"CVE-1"}}))
+	assert.NoError(t, err)
+	assert.Empty(t, combinations)
+
+	_, err = table.quals(queryData(map[string][]interface{}{"cluster_id": {"a"}}))
+	assert.EqualError(t, err, "you must specify the cve_name")
+}
+
+func TestEndpointTableRows(t *testing.T) {
+	quals := map[string]string{"cluster_id": "42"}
+	item := endpointTestItem{Name: "ubi8"}
+	assert.Equal(t, []EndpointRow{{Item: item, Quals: quals}}, endpointTestTable.rows(item, quals))
+
+	table := endpointTestTable
+	table.Rows = func(item endpointTestItem) []interface{} {
+		return []interface{}{map[string]interface{}{"name": item.Name}, map[string]interface{}{"name": item.Name + "-minimal"}}
+	}
+	rows := table.rows(item, quals)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, map[string]interface{}{"name": "ubi8-minimal"}, rows[1].Item)
+		assert.Equal(t, quals, rows[1].Quals)
+	}
+}
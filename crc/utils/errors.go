@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// RequestIDHeader is the header identifying a request across the console.redhat.com services
+const RequestIDHeader = "x-rh-insights-request-id"
+
+// DefaultIgnoreErrorCodes are the status codes returning zero rows instead of
+// failing the query when 'ignore_error_codes' is not set
+var DefaultIgnoreErrorCodes = []int{http.StatusNotFound}
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Method     string
+	Endpoint   string
+	Body       string
+	RequestID  string
+	Header     http.Header
+}
+
+func (e *APIError) Error() string {
+	var requestID string
+	if e.RequestID != "" {
+		requestID = fmt.Sprintf(" (request ID %s)", e.RequestID)
+	}
+	return fmt.Sprintf("API request %s %s failed with status code %d%s and body: %s", e.Method, e.Endpoint, e.StatusCode, requestID, e.Body)
+}
+
+// newAPIError builds the error for an unexpected response, whose body has already been read
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     resp.Request.Method,
+		Endpoint:   strings.TrimPrefix(resp.Request.URL.Path, "/"),
+		Body:       string(body),
+		RequestID:  resp.Header.Get(RequestIDHeader),
+		Header:     resp.Header,
+	}
+}
+
+// ShouldIgnoreError reports whether the error is an APIError whose status
+// code is listed in 'ignore_error_codes', or in DefaultIgnoreErrorCodes if
+// the option is not set
+func ShouldIgnoreError(_ context.Context, d *plugin.QueryData, _ *plugin.HydrateData, err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	codes := DefaultIgnoreErrorCodes
+	if config := GetConfig(d.Connection); config.IgnoreErrorCodes != nil {
+		codes = *config.IgnoreErrorCodes
+	}
+
+	return slices.Contains(codes, apiErr.StatusCode)
+}
+
+// IgnoreConfig returns the ignore rules shared by the List and Get configs of every table
+func IgnoreConfig() *plugin.IgnoreConfig {
+	return &plugin.IgnoreConfig{
+		ShouldIgnoreErrorFunc: ShouldIgnoreError,
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "0123456789abcdef")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"status": "Item with ID 42 was not found in the storage"}`)
+	}))
+	defer server.Close()
+
+	_, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL+"/api/insights-results-aggregator/v2/cluster/42/reports", nil, nil, testRetryPolicy)
+
+	var apiErr *APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		// the headers of the response are kept
+		assert.Equal(t, "0123456789abcdef", apiErr.Header.Get(RequestIDHeader))
+		apiErr.Header = nil
+		assert.Equal(t, &APIError{
+			StatusCode: http.StatusNotFound,
+			Method:     "GET",
+			Endpoint:   "api/insights-results-aggregator/v2/cluster/42/reports",
+			Body:       `{"status": "Item with ID 42 was not found in the storage"}`,
+			RequestID:  "0123456789abcdef",
+		}, apiErr)
+	}
+	assert.EqualError(t, err, `API request GET api/insights-results-aggregator/v2/cluster/42/reports failed with status code 404 (request ID 0123456789abcdef) and body: {"status": "Item with ID 42 was not found in the storage"}`)
+}
+
+func TestShouldIgnoreError(t *testing.T) {
+	notFound := &APIError{StatusCode: http.StatusNotFound}
+	forbidden := fmt.Errorf("wrapped: %w", &APIError{StatusCode: http.StatusForbidden})
+
+	d := &plugin.QueryData{Connection: &plugin.Connection{Config: crcConfig{}}}
+	assert.True(t, ShouldIgnoreError(context.Background(), d, nil, notFound))
+	assert.False(t, ShouldIgnoreError(context.Background(), d, nil, forbidden))
+	assert.False(t, ShouldIgnoreError(context.Background(), d, nil, errors.New("error making request: EOF")))
+
+	codes := []int{http.StatusForbidden}
+	d = &plugin.QueryData{Connection: &plugin.Connection{Config: crcConfig{IgnoreErrorCodes: &codes}}}
+	assert.False(t, ShouldIgnoreError(context.Background(), d, nil, notFound))
+	assert.True(t, ShouldIgnoreError(context.Background(), d, nil, forbidden))
+
+	// an empty list disables the default rules
+	codes = []int{}
+	assert.False(t, ShouldIgnoreError(context.Background(), d, nil, notFound))
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// DefaultMaxConcurrency is how many requests a query sends at once when it
+// fans out to several endpoints, e.g. one per cluster
+const DefaultMaxConcurrency = 5
+
+// maxConcurrencyFromConfig returns the 'max_concurrency' option, or its default
+func maxConcurrencyFromConfig(config crcConfig) (int, error) {
+	if config.MaxConcurrency == nil {
+		return DefaultMaxConcurrency, nil
+	}
+	if *config.MaxConcurrency < 1 {
+		return 0, fmt.Errorf("'max_concurrency' must be at least 1, got %d", *config.MaxConcurrency)
+	}
+	return *config.MaxConcurrency, nil
+}
+
+// streamLockKey is the context key of the lock shared by the workers of FanOut
+type streamLockKey struct{}
+
+// lockStream locks the streaming of the rows if the context is the one of the
+// workers of FanOut, since the QueryData isn't safe for concurrent use, and
+// returns the function unlocking it
+func lockStream(ctx context.Context) func() {
+	mu, ok := ctx.Value(streamLockKey{}).(*sync.Mutex)
+	if !ok {
+		return func() {}
+	}
+	mu.Lock()
+	return mu.Unlock
+}
+
+// RowsRemaining returns the number of rows Steampipe still needs, like
+// d.RowsRemaining, but can be called by the workers of FanOut
+func RowsRemaining(ctx context.Context, d *plugin.QueryData) int64 {
+	defer lockStream(ctx)()
+	return d.RowsRemaining(ctx)
+}
+
+// FanOut calls fetch for each value with a pool of at most 'max_concurrency'
+// workers. Each call waits for the rate limiters of the table first, since
+// Steampipe only waited for them once before the List hydrate. No new call is
+// started once Steampipe doesn't need more rows or a call failed, and the
+// first error is returned. The calls must stream their rows with
+// StreamListItem and check RowsRemaining rather than the methods of the QueryData.
+func FanOut(ctx context.Context, d *plugin.QueryData, values []string, fetch func(ctx context.Context, value string) error) error {
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return err
+	}
+	workers, err := maxConcurrencyFromConfig(config)
+	if err != nil {
+		return err
+	}
+	workers = min(workers, len(values))
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, streamLockKey{}, &sync.Mutex{}))
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for value := range queue {
+				if ctx.Err() != nil || RowsRemaining(ctx, d) == 0 {
+					continue
+				}
+				d.WaitForListRateLimit(ctx)
+				if err := fetch(ctx, value); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for _, value := range values {
+		if ctx.Err() != nil || RowsRemaining(ctx, d) == 0 {
+			break
+		}
+		select {
+		case queue <- value:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return firstErr
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,25 +15,49 @@ import (
 	"time"
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultTimeout bounds the API requests, unless the endpoint or the
+// connection configuration sets another timeout
 const DefaultTimeout = 20 * time.Second
 
-// SSOClient struct to hold SSO credentials and token
+// TokenTimeout bounds the requests to the SSO token endpoint
+const TokenTimeout = 10 * time.Second
+
+// OfflineTokenClientID is the SSO client the console.redhat.com offline tokens are issued for
+const OfflineTokenClientID = "cloud-services"
+
+// SSOClient struct to hold SSO credentials and token.
+//
+// The token is guarded by a read/write lock so that requests sharing a valid
+// token run concurrently. Only token refreshes are serialized, and a single
+// refresh is performed no matter how many requests find the token expired.
+// Requests waiting for a refresh give up as soon as their context is done.
+//
+// When RefreshToken is set, tokens are obtained with the refresh_token grant
+// instead of the client_credentials one, and the refresh token is replaced
+// by the one returned by the SSO server, if any.
 type SSOClient struct {
 	ClientID     string
 	ClientSecret string
+	RefreshToken string
 	Token        string
 	TokenURL     string
 	TokenExpiry  time.Time
-	sync.Mutex
-	Transport http.RoundTripper
+	Transport    http.RoundTripper
+
+	tracer      trace.Tracer  // records the spans of the token requests, if not nil
+	tokenMu     sync.RWMutex  // guards Token and TokenExpiry
+	refreshOnce sync.Once     // initializes refreshLock
+	refreshLock chan struct{} // serializes calls to authenticate
 }
 
 // TokenResponse struct to unmarshal the token response
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int64  `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // NewSSOClient creates a new SSOClient
@@ -45,110 +70,363 @@ func NewSSOClient(clientID, clientSecret, tokenURL string) *SSOClient {
 	}
 }
 
+// NewOfflineTokenSSOClient creates a new SSOClient authenticating with an offline token
+func NewOfflineTokenSSOClient(offlineToken, tokenURL string) *SSOClient {
+	return &SSOClient{
+		ClientID:     OfflineTokenClientID,
+		RefreshToken: offlineToken,
+		TokenURL:     tokenURL,
+		Transport:    http.DefaultTransport,
+	}
+}
+
 // RoundTrip implements the RoundTripper interface
 func (c *SSOClient) RoundTrip(req *http.Request) (*http.Response, error) {
-	c.Lock()
-	defer c.Unlock()
-
-	if time.Now().After(c.TokenExpiry) {
-		if err := c.authenticate(); err != nil {
-			return nil, err
-		}
+	token, err := c.accessToken(req.Context())
+	if err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.Token)
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
 	return c.Transport.RoundTrip(req)
 }
 
+// accessToken returns a valid token, refreshing it first if it has expired
+func (c *SSOClient) accessToken(ctx context.Context) (string, error) {
+	if token, ok := c.currentToken(); ok {
+		return token, nil
+	}
+
+	c.refreshOnce.Do(func() {
+		c.refreshLock = make(chan struct{}, 1)
+	})
+	select {
+	case c.refreshLock <- struct{}{}:
+		defer func() { <-c.refreshLock }()
+	case <-ctx.Done():
+		return "", fmt.Errorf("authenticate - waiting for the token refresh: %v", ctx.Err())
+	}
+
+	// another request may have refreshed the token while we were waiting
+	if token, ok := c.currentToken(); ok {
+		return token, nil
+	}
+
+	if err := c.authenticate(ctx); err != nil {
+		return "", err
+	}
+
+	token, _ := c.currentToken()
+	return token, nil
+}
+
+// currentToken returns the cached token and whether it is still valid
+func (c *SSOClient) currentToken() (string, bool) {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.Token, c.Token != "" && time.Now().Before(c.TokenExpiry)
+}
+
 // authenticate authenticates to the SSO server and retrieves a token
-func (c *SSOClient) authenticate() error {
+func (c *SSOClient) authenticate(ctx context.Context) (err error) {
 	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
+	if c.RefreshToken != "" {
+		data.Set("grant_type", "refresh_token")
+		data.Set("client_id", c.ClientID)
+		data.Set("refresh_token", c.RefreshToken)
+	} else {
+		data.Set("grant_type", "client_credentials")
+	}
+
+	tracer := c.tracer
+	if tracer == nil {
+		tracer = tracerOrNoop(nil, nil)
+	}
+	ctx, span := tracer.Start(ctx, "SSOClient.authenticate", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(GrantTypeAttribute.String(data.Get("grant_type"))))
+	var statusCode int
+	defer func() { endSpan(span, statusCode, err) }()
 
-	req, err := http.NewRequest("POST", c.TokenURL, bytes.NewBufferString(data.Encode()))
+	ctx, cancel := context.WithTimeout(ctx, TokenTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.TokenURL, bytes.NewBufferString(data.Encode()))
 	if err != nil {
 		return fmt.Errorf("authenticate - error creating request: %v", err)
 	}
 
-	req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	if c.RefreshToken == "" {
+		req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
+	// the logs never contain the credentials nor the tokens
+	log, start := logger(ctx), time.Now()
+	if log.IsTrace() {
+		log.Trace("sending token request", "token_url", c.TokenURL, "form", redactForm(data), "header", RedactHeader(req.Header))
+	}
+
+	client := &http.Client{Transport: c.Transport, Timeout: TokenTimeout}
 	resp, err := client.Do(req)
 	if err != nil {
+		log.Debug("token request failed", "token_url", c.TokenURL, "duration", time.Since(start), "error", err)
 		return fmt.Errorf("authenticate - error making request: %v", err)
 	}
 	defer resp.Body.Close()
+	statusCode = resp.StatusCode
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("authenticate - error reading response: %v", err)
 	}
+	log.Debug("token request done", "token_url", c.TokenURL, "grant_type", data.Get("grant_type"), "status", resp.StatusCode,
+		"bytes", len(body), "duration", time.Since(start), "request_id", resp.Header.Get(RequestIDHeader))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("authenticate - token request failed with status code %d and body: %s", resp.StatusCode, string(body))
+	}
 
 	var tokenResponse TokenResponse
 	if err := json.Unmarshal(body, &tokenResponse); err != nil {
 		return fmt.Errorf("authenticate - error parsing JSON: %v", err)
 	}
 
+	// refresh tokens may be rotated by the SSO server
+	if c.RefreshToken != "" && tokenResponse.RefreshToken != "" {
+		c.RefreshToken = tokenResponse.RefreshToken
+	}
+
+	c.tokenMu.Lock()
 	c.Token = tokenResponse.AccessToken
 	c.TokenExpiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	c.tokenMu.Unlock()
 
 	return nil
 }
 
 // newAuthenticatedClient returns an HTTP client with SSO authentication
-func newAuthenticatedClient(clientID, clientSecret, tokenURL string, timeout time.Duration) *http.Client {
-	ssoClient := NewSSOClient(clientID, clientSecret, tokenURL)
+func newAuthenticatedClient(ssoClient *SSOClient, timeout time.Duration) *http.Client {
 	return &http.Client{
 		Transport: ssoClient,
 		Timeout:   timeout,
 	}
 }
 
-// GetConsoleDotClient returns an HTTP client with SSO authentication for the console.redhat.com APIs
-func GetConsoleDotClient(_ context.Context, d *plugin.QueryData, timeout time.Duration) (*http.Client, error) {
+// consoleDotClient is the authenticated HTTP client of a connection along
+// with the base URL of the APIs it queries. The client has no timeout: each
+// request sets its own, see timeouts.
+type consoleDotClient struct {
+	client   *http.Client
+	baseURL  string
+	sso      *SSOClient
+	timeouts *timeouts
+	settings connectionSettings
+	// cache is the disk cache of the connection, nil if 'cache_dir' is not set
+	cache *diskCache
+}
+
+// credentialEnvVars are the environment variables the connection settings may be read from
+var credentialEnvVars = []string{"CRC_URL", "CRC_TOKEN_URL", "CRC_CLIENT_ID", "CRC_CLIENT_SECRET", "CRC_OFFLINE_TOKEN", "OCM_CONFIG"}
+
+// clientCacheKey returns the cache key of the client of a connection. It
+// includes a fingerprint of the settings so that two connections, e.g. to
+// different organizations, never share an authenticated client.
+func clientCacheKey(connectionName string, config crcConfig) string {
+	hash := sha256.New()
+	_ = json.NewEncoder(hash).Encode(config)
+	for _, name := range credentialEnvVars {
+		fmt.Fprintf(hash, "%s=%s\n", name, os.Getenv(name))
+	}
+	return fmt.Sprintf("crc-client-%s-%x", connectionName, hash.Sum(nil)[:8])
+}
+
+// The sources of the connection settings, see connectionSettings.Sources
+const (
+	SettingSourceConfig = "config"
+	SettingSourceEnv    = "env"
+	SettingSourceOCM    = "ocm"
+	SettingSourceReplay = "replay"
+)
+
+// connectionSettings are the settings of a connection, resolved from the
+// connection configuration, the environment variables and the ocm CLI
+// configuration, in this order of precedence
+type connectionSettings struct {
+	BaseURL      string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	OfflineToken string
+	// OCM is set when the credentials are read from the ocm CLI configuration
+	OCM *ocmConfig
+	// Sources tells where each setting which is set comes from, by option
+	// name: the connection configuration, an environment variable (e.g.
+	// "env:CRC_URL") or the ocm CLI configuration (e.g. "ocm:/home/me/.config/ocm/ocm.json")
+	Sources map[string]string
+}
+
+// resolveConnectionSettings resolves the settings of the connection. The ocm
+// CLI configuration is only read when neither client credentials nor an
+// offline token are set.
+func resolveConnectionSettings(config crcConfig) (connectionSettings, error) {
+	settings := connectionSettings{Sources: map[string]string{}}
+	settings.resolve("base_url", &settings.BaseURL, config.BaseUrl, "CRC_URL")
+	settings.resolve("token_url", &settings.TokenURL, config.TokenURL, "CRC_TOKEN_URL")
+	settings.resolve("client_id", &settings.ClientID, config.ClientID, "CRC_CLIENT_ID")
+	settings.resolve("client_secret", &settings.ClientSecret, config.ClientSecret, "CRC_CLIENT_SECRET")
+	settings.resolve("offline_token", &settings.OfflineToken, config.OfflineToken, "CRC_OFFLINE_TOKEN")
+
+	// Fall back to the credentials of the ocm CLI
+	if settings.ClientID == "" && settings.ClientSecret == "" && settings.OfflineToken == "" {
+		ocm, err := loadOCMConfig(config.OCMConfigPath)
+		if err != nil {
+			return settings, err
+		}
+		if ocm != nil {
+			source := SettingSourceOCM + ":" + ocm.path
+			settings.OCM = ocm
+			settings.Sources["credentials"] = source
+			if settings.TokenURL == "" {
+				settings.TokenURL = ocm.tokenURL()
+				settings.Sources["token_url"] = source
+			}
+			if settings.BaseURL == "" {
+				settings.BaseURL = ocm.consoleURL()
+				if settings.BaseURL != "" {
+					settings.Sources["base_url"] = source
+				}
+			}
+		}
+	}
+
+	if settings.BaseURL == "" {
+		return settings, errors.New("'base_url' must be set in the connection configuration")
+	}
+	if settings.TokenURL == "" {
+		return settings, errors.New("'token_url' must be set in the connection configuration")
+	}
+
+	return settings, nil
+}
+
+// resolve sets the setting from the option of the connection configuration,
+// or else from the environment variable, recording its source
+func (s *connectionSettings) resolve(option string, setting *string, configValue *string, envVar string) {
+	// Prefer config options given in Steampipe
+	if configValue != nil {
+		*setting = *configValue
+		s.Sources[option] = SettingSourceConfig
+		return
+	}
+	if value := os.Getenv(envVar); value != "" {
+		*setting = value
+		s.Sources[option] = SettingSourceEnv + ":" + envVar
+	}
+}
+
+// ssoClient returns the SSO client for the resolved credentials. Service
+// account credentials are preferred over the offline token, which is
+// preferred over the ocm CLI credentials.
+func (s connectionSettings) ssoClient() (*SSOClient, error) {
+	switch {
+	case s.ClientID != "" && s.ClientSecret != "":
+		return NewSSOClient(s.ClientID, s.ClientSecret, s.TokenURL), nil
+	case s.OfflineToken != "":
+		return NewOfflineTokenSSOClient(s.OfflineToken, s.TokenURL), nil
+	case s.ClientID != "":
+		return nil, errors.New("'client_secret' must be set in the connection configuration")
+	case s.ClientSecret != "":
+		return nil, errors.New("'client_id' must be set in the connection configuration")
+	case s.OCM != nil:
+		return s.OCM.ssoClient(s.TokenURL)
+	default:
+		return nil, errors.New("either 'client_id' and 'client_secret' or 'offline_token' must be set in the connection configuration, or you must be logged in with the ocm CLI")
+	}
+}
+
+// getConsoleDotClient returns the cached client of the connection, creating it if needed
+func getConsoleDotClient(_ context.Context, d *plugin.QueryData) (*consoleDotClient, error) {
+	var connectionName string
+	if d.Connection != nil {
+		connectionName = d.Connection.Name
+	}
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load connection from cache, which preserves throttling protection etc
-	cacheKey := "crc"
+	cacheKey := clientCacheKey(connectionName, config)
 	if cachedData, ok := d.ConnectionManager.Cache.Get(cacheKey); ok {
-		return cachedData.(*http.Client), nil
+		return cachedData.(*consoleDotClient), nil
 	}
 
-	// Default to the env var settings
-	baseUrl := os.Getenv("CRC_URL")
-	tokenURL := os.Getenv("CRC_TOKEN_URL")
-	clientID := os.Getenv("CRC_CLIENT_ID")
-	clientSecret := os.Getenv("CRC_CLIENT_SECRET")
+	// already checked when the connection loaded, unless the table map wasn't built
+	if _, err := LoadValidConfig(d.Connection); err != nil {
+		return nil, err
+	}
+	mode, _ := replayMode(config)
 
-	// Prefer config options given in Steampipe
-	crcConfig := GetConfig(d.Connection)
+	// replays don't need any credentials
+	settingsConfig := config
+	if mode == ReplayModeReplay {
+		settingsConfig = withReplayDefaults(config)
+	}
 
-	if crcConfig.BaseUrl != nil {
-		baseUrl = *crcConfig.BaseUrl
+	settings, err := resolveConnectionSettings(settingsConfig)
+	if err != nil {
+		return nil, err
 	}
-	if crcConfig.TokenURL != nil {
-		tokenURL = *crcConfig.TokenURL
+	if mode == ReplayModeReplay {
+		settings.markReplayDefaults(config)
 	}
-	if crcConfig.ClientID != nil {
-		clientID = *crcConfig.ClientID
+	if err := settings.normalize(settingsConfig); err != nil {
+		return nil, fmt.Errorf("invalid configuration of the connection %q:\n%w", connectionName, err)
 	}
-	if crcConfig.ClientSecret != nil {
-		clientSecret = *crcConfig.ClientSecret
+
+	ssoClient, err := settings.ssoClient()
+	if err != nil {
+		return nil, err
 	}
 
-	if baseUrl == "" {
-		return nil, errors.New("'base_url' must be set in the connection configuration")
+	tracerProvider, err := tracerProviderFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
-	if tokenURL == "" {
-		return nil, errors.New("'token_url' must be set in the connection configuration")
+	ssoClient.tracer = tracerProvider.Tracer(TracerName)
+
+	// the API and the token requests share the same proxy and TLS settings
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
 	}
-	if clientID == "" {
-		return nil, errors.New("'client_id' must be set in the connection configuration")
+
+	// the cassettes sit under the SSO client so that they also cover the token requests
+	ssoClient.Transport, err = newCassetteTransport(config, transport)
+	if err != nil {
+		return nil, err
 	}
-	if clientSecret == "" {
-		return nil, errors.New("'client_secret' must be set in the connection configuration")
+
+	timeouts, err := timeoutsFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
-	client := newAuthenticatedClient(clientID, clientSecret, tokenURL, timeout)
+	cache, err := diskCacheFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &consoleDotClient{
+		client:   newAuthenticatedClient(ssoClient, 0),
+		baseURL:  settings.BaseURL,
+		sso:      ssoClient,
+		timeouts: timeouts,
+		settings: settings,
+		cache:    cache.forClient(connectionName, cacheKey),
+	}
 
 	// Save to cache
 	d.ConnectionManager.Cache.Set(cacheKey, client)
@@ -156,3 +434,16 @@ func GetConsoleDotClient(_ context.Context, d *plugin.QueryData, timeout time.Du
 	// Done
 	return client, nil
 }
+
+// GetConsoleDotClient returns an HTTP client with SSO authentication for the
+// console.redhat.com APIs, whose requests time out after the given timeout
+// unless the connection configuration overrides it
+func GetConsoleDotClient(ctx context.Context, d *plugin.QueryData, timeout time.Duration) (*http.Client, error) {
+	client, err := getConsoleDotClient(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := *client.client
+	httpClient.Timeout = client.timeouts.timeout(tableService(d), timeout)
+	return &httpClient, nil
+}
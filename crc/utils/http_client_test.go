@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const parallelRequests = 10
+
+// newTokenServer returns a stub SSO server that counts the tokens it issues
+func newTokenServer(t *testing.T, issued *int32, delay time.Duration) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 300}`, n)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSSOClientRequestsOverlap(t *testing.T) {
+	var issued int32
+	tokenServer := newTokenServer(t, &issued, 0)
+
+	var inFlight, maxInFlight int32
+	arrived := make(chan struct{}, parallelRequests)
+	release := make(chan struct{})
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			observed := atomic.LoadInt32(&maxInFlight)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		arrived <- struct{}{}
+		<-release
+		assert.Equal(t, "Bearer token-1", r.Header.Get("Authorization"))
+	}))
+	defer apiServer.Close()
+
+	client := newAuthenticatedClient(NewSSOClient("id", "secret", tokenServer.URL), DefaultTimeout)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(apiServer.URL)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	// every request must reach the server before any of them is allowed to finish
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < parallelRequests; i++ {
+		select {
+		case <-arrived:
+		case <-timeout:
+			close(release)
+			t.Fatalf("only %d of %d requests were in flight at the same time", i, parallelRequests)
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(parallelRequests), atomic.LoadInt32(&maxInFlight))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+}
+
+func TestSSOClientSingleFlightRefresh(t *testing.T) {
+	var issued int32
+	tokenServer := newTokenServer(t, &issued, 50*time.Millisecond)
+
+	ssoClient := NewSSOClient("id", "secret", tokenServer.URL)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, parallelRequests)
+	for i := 0; i < parallelRequests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := ssoClient.accessToken(context.Background())
+			assert.NoError(t, err)
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+	for _, token := range tokens {
+		assert.Equal(t, "token-1", token)
+	}
+
+	// an expired token is refreshed exactly once more
+	ssoClient.tokenMu.Lock()
+	ssoClient.TokenExpiry = time.Now().Add(-time.Second)
+	ssoClient.tokenMu.Unlock()
+
+	token, err := ssoClient.accessToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+}
+
+func TestSSOClientHonorsCancellation(t *testing.T) {
+	// the token endpoint hangs until the test is over
+	done := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer tokenServer.Close()
+	defer close(done)
+
+	ssoClient := NewSSOClient("id", "secret", tokenServer.URL)
+
+	// the first request hangs on the token endpoint while holding the refresh
+	// lock, the others wait for it: all of them must return once cancelled
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < parallelRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := ssoClient.accessToken(ctx)
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Less(t, time.Since(start), TokenTimeout)
+}
+
+func TestSSOClientOfflineToken(t *testing.T) {
+	var issued int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		_, _, hasBasicAuth := r.BasicAuth()
+		assert.False(t, hasBasicAuth)
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		assert.Equal(t, OfflineTokenClientID, r.PostForm.Get("client_id"))
+
+		// every refresh must use the token rotated by the previous one
+		n := atomic.AddInt32(&issued, 1)
+		expected := "offline"
+		if n > 1 {
+			expected = fmt.Sprintf("rotated-%d", n-1)
+		}
+		if r.PostForm.Get("refresh_token") != expected {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 300, "refresh_token": "rotated-%d"}`, n, n)
+	}))
+	defer tokenServer.Close()
+
+	ssoClient := NewOfflineTokenSSOClient("offline", tokenServer.URL)
+	for i := 1; i <= 3; i++ {
+		token, err := ssoClient.accessToken(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("token-%d", i), token)
+		assert.Equal(t, fmt.Sprintf("rotated-%d", i), ssoClient.RefreshToken)
+
+		ssoClient.tokenMu.Lock()
+		ssoClient.TokenExpiry = time.Now().Add(-time.Second)
+		ssoClient.tokenMu.Unlock()
+	}
+
+	// a revoked offline token is reported instead of sending unauthenticated requests
+	ssoClient = NewOfflineTokenSSOClient("revoked", tokenServer.URL)
+	_, err := ssoClient.accessToken(context.Background())
+	assert.ErrorContains(t, err, "status code 400")
+}
+
+func TestClientCacheKey(t *testing.T) {
+	clearCredentialsEnv(t)
+	prodOrg, otherOrg := "org-1", "org-2"
+
+	key := clientCacheKey("crc_prod", crcConfig{ClientID: &prodOrg})
+	assert.Equal(t, key, clientCacheKey("crc_prod", crcConfig{ClientID: &prodOrg}))
+	assert.NotEqual(t, key, clientCacheKey("crc_stage", crcConfig{ClientID: &prodOrg}))
+	assert.NotEqual(t, key, clientCacheKey("crc_prod", crcConfig{ClientID: &otherOrg}))
+
+	// credentials read from the environment are part of the fingerprint too
+	t.Setenv("CRC_OFFLINE_TOKEN", "offline")
+	assert.NotEqual(t, key, clientCacheKey("crc_prod", crcConfig{ClientID: &prodOrg}))
+}
@@ -4,49 +4,164 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// MakeAPIRequest makes an API request to the specified endpoint
+// MakeAPIRequest makes an API request to the specified endpoint. The request
+// times out after the given timeout, unless the connection configuration sets
+// another timeout for all the requests or for the service of the table.
 func MakeAPIRequest(ctx context.Context, d *plugin.QueryData, method, endpoint string, body interface{}, timeout time.Duration) (*http.Response, error) {
-	client, err := GetConsoleDotClient(ctx, d, timeout)
+	template := requestTemplate(ctx, endpoint)
+	ctx, span := tracer(d).Start(ctx, method+" "+template, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		TableAttribute.String(tableName(d)),
+		MethodAttribute.String(method),
+		URLTemplateAttribute.String(template),
+	))
+
+	resp, err := makeAPIRequest(ctx, d, method, endpoint, body, timeout)
+
+	var statusCode int
+	if resp != nil {
+		statusCode = resp.StatusCode
+		resp.Body = &responseBody{ReadCloser: resp.Body, endpoint: template}
+	}
+	endSpan(span, statusCode, err)
+
+	return resp, err
+}
+
+// makeAPIRequest implements MakeAPIRequest
+func makeAPIRequest(ctx context.Context, d *plugin.QueryData, method, endpoint string, body interface{}, timeout time.Duration) (*http.Response, error) {
+	client, err := getConsoleDotClient(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client is shared by the queries of the connection, each request sets its timeout
+	service := tableService(d)
+	timeout = client.timeouts.timeout(service, timeout)
+	httpClient := *client.client
+	httpClient.Timeout = timeout
+
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy, err := retryPolicyFromConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	baseURL := GetConfig(d.Connection).BaseUrl
-	url := *baseURL + endpoint
+	url := client.baseURL + endpoint
+	send := func(header http.Header) (*http.Response, error) {
+		if err := waitForConnectionRateLimit(ctx, d); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		resp, err := doAPIRequest(ctx, &httpClient, method, url, header, body, retryPolicy)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				client.timeouts.observe(service, timeout)
+			}
+			return nil, err
+		}
+		resp.Body = &timedBody{ReadCloser: resp.Body, latency: time.Since(start), onDone: func(latency time.Duration) {
+			client.timeouts.observe(service, latency)
+		}}
+		return resp, nil
+	}
 
-	var reqBody io.Reader
+	if client.cache == nil || method != http.MethodGet || isDiskCacheDisabled(ctx) {
+		return send(nil)
+	}
+	return doCachedAPIRequest(d, client.cache, url, send)
+}
+
+// doAPIRequest sends the request with the extra headers, retrying transient
+// failures of idempotent methods according to the retry policy
+func doAPIRequest(ctx context.Context, client *http.Client, method, url string, header http.Header, body interface{}, retryPolicy RetryPolicy) (*http.Response, error) {
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		var err error
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("error marshalling request body: %v", err)
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
+	callLog := newAPICallLog(ctx, method, url)
+	retryable := isIdempotent(method)
+	for attempt := 1; ; attempt++ {
+		var reqBody io.Reader
+		if jsonBody != nil {
+			reqBody = bytes.NewReader(jsonBody)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
-	}
+		canRetry := retryable && attempt < retryPolicy.MaxAttempts
+
+		callLog.attempt(req, attempt)
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				callLog.failed(ctx.Err())
+				return nil, fmt.Errorf("error making request: %v", ctx.Err())
+			}
+			if canRetry && isRetryableError(err) {
+				delay := retryPolicy.backoff(attempt)
+				callLog.retry(err.Error(), delay)
+				if err := sleep(ctx, delay); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			callLog.failed(err)
+			return nil, fmt.Errorf("error making request: %w", err)
+		}
+
+		// a 304 answers the revalidation of a cached response
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified && len(header) > 0 {
+			callLog.doneWhenRead(resp)
+			return resp, nil
+		}
 
-	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status code %d and body: %s", resp.StatusCode, string(bodyBytes))
-	}
+		resp.Body.Close()
+		callLog.done(resp, int64(len(bodyBytes)))
+
+		if canRetry && isRetryableStatus(resp.StatusCode) {
+			delay := retryPolicy.backoff(attempt)
+			if wait, ok := retryAfter(resp); ok {
+				delay = wait
+			}
+			// don't retry if the server asks us to wait longer than we are willing to
+			if delay <= retryPolicy.MaxDelay {
+				callLog.retry(resp.Status, delay)
+				if err := sleep(ctx, delay); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
 
-	return resp, nil
+		return nil, newAPIError(resp, bodyBytes)
+	}
 }
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// The kinds of credentials a connection authenticates with
+const (
+	CredentialsClientCredentials = "client_credentials"
+	CredentialsOfflineToken      = "offline_token"
+	CredentialsOCM               = "ocm"
+)
+
+// SettingSourceToken is the source of the organization ID when it is read
+// from the access token rather than from the connection configuration
+const SettingSourceToken = "token"
+
+// Identity is who a connection is authenticated as, as claimed by its access
+// token, along with the effective settings of the connection
+type Identity struct {
+	BaseURL     string
+	TokenURL    string
+	Credentials string
+	// Sources tells where each setting comes from, e.g. "config" or "env:CRC_URL"
+	Sources map[string]string
+
+	// AuthenticationError is set when no access token could be obtained, and
+	// the claims are then empty
+	AuthenticationError string
+	// ConnectionOrgID is the organization ID of the connection, the org_id
+	// option or else the one claimed by the access token
+	ConnectionOrgID string
+
+	// Claims are the claims of the access token, if it is a JWT
+	Claims        map[string]interface{}
+	OrgID         string
+	AccountNumber string
+	Username      string
+	Scopes        []string
+	TokenExpiry   time.Time
+}
+
+// GetIdentity returns the identity of the connection, authenticating first
+// if needed. An authentication failure is reported in the identity rather
+// than returned, so that the settings leading to it can be checked.
+func GetIdentity(ctx context.Context, d *plugin.QueryData) (*Identity, error) {
+	client, err := getConsoleDotClient(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := client.settings
+	identity := &Identity{
+		BaseURL:     client.baseURL,
+		TokenURL:    settings.TokenURL,
+		Credentials: settings.credentials(),
+		Sources:     map[string]string{},
+	}
+	for option, source := range settings.Sources {
+		identity.Sources[option] = source
+	}
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return nil, err
+	}
+	if config.OrgID != nil {
+		identity.ConnectionOrgID = *config.OrgID
+		identity.Sources["org_id"] = SettingSourceConfig
+	} else {
+		identity.Sources["org_id"] = SettingSourceToken
+	}
+
+	token, err := client.sso.accessToken(ctx)
+	if err != nil {
+		identity.AuthenticationError = err.Error()
+		return identity, nil
+	}
+	client.sso.tokenMu.RLock()
+	identity.TokenExpiry = client.sso.TokenExpiry
+	client.sso.tokenMu.RUnlock()
+
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		// opaque tokens don't tell who they were issued to
+		return identity, nil
+	}
+	identity.setClaims(claims)
+	if config.OrgID == nil {
+		identity.ConnectionOrgID = identity.OrgID
+	}
+	return identity, nil
+}
+
+// setClaims sets the identity claimed by a Red Hat SSO token
+func (i *Identity) setClaims(claims map[string]interface{}) {
+	i.Claims = claims
+	i.OrgID = orgIDFromClaims(claims)
+	i.AccountNumber = stringClaim(claims, "account_number")
+	if i.AccountNumber == "" {
+		if organization, ok := claims["organization"].(map[string]interface{}); ok {
+			i.AccountNumber = stringClaim(organization, "account_number")
+		}
+	}
+	i.Username = stringClaim(claims, "preferred_username")
+	if i.Username == "" {
+		i.Username = stringClaim(claims, "username")
+	}
+	i.Scopes = strings.Fields(stringClaim(claims, "scope"))
+	if exp, ok := claims["exp"].(float64); ok {
+		i.TokenExpiry = time.Unix(int64(exp), 0).UTC()
+	}
+}
+
+// stringClaim returns the claim if it is a string
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// credentials returns the kind of credentials the settings authenticate
+// with, following the precedence of ssoClient
+func (s connectionSettings) credentials() string {
+	switch {
+	case s.ClientID != "" && s.ClientSecret != "":
+		return CredentialsClientCredentials
+	case s.OfflineToken != "":
+		return CredentialsOfflineToken
+	case s.OCM != nil:
+		return CredentialsOCM
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityClaims(t *testing.T) {
+	claims, err := decodeJWTClaims(fakeJWT(`{
+		"exp": 4102444800,
+		"organization": {"id": "11789772", "account_number": "5910538"},
+		"username": "someone",
+		"scope": "openid  api.console"
+	}`))
+	assert.NoError(t, err)
+
+	var identity Identity
+	identity.setClaims(claims)
+	assert.Equal(t, "11789772", identity.OrgID)
+	assert.Equal(t, "5910538", identity.AccountNumber)
+	assert.Equal(t, "someone", identity.Username)
+	assert.Equal(t, []string{"openid", "api.console"}, identity.Scopes)
+	assert.Equal(t, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), identity.TokenExpiry)
+
+	// the top level claims are preferred
+	identity.setClaims(map[string]interface{}{"account_number": "1", "preferred_username": "me", "username": "someone"})
+	assert.Equal(t, "1", identity.AccountNumber)
+	assert.Equal(t, "me", identity.Username)
+	assert.Empty(t, identity.Scopes)
+}
+
+func TestConnectionSettingsSources(t *testing.T) {
+	clearCredentialsEnv(t)
+	t.Setenv("CRC_URL", "https://console.redhat.com/")
+	tokenURL, clientID, clientSecret := "https://sso.example.com/token", "id", "secret"
+
+	settings, err := resolveConnectionSettings(crcConfig{TokenURL: &tokenURL, ClientID: &clientID, ClientSecret: &clientSecret})
+	assert.NoError(t, err)
+	assert.Equal(t, CredentialsClientCredentials, settings.credentials())
+	assert.Equal(t, map[string]string{
+		"base_url":      "env:CRC_URL",
+		"token_url":     "config",
+		"client_id":     "config",
+		"client_secret": "config",
+	}, settings.Sources)
+
+	// the token URL and the credentials come from the ocm CLI configuration
+	path := writeOCMConfig(t, `{"refresh_token": "stored-refresh-token"}`)
+	settings, err = resolveConnectionSettings(crcConfig{OCMConfigPath: &path})
+	assert.NoError(t, err)
+	assert.Equal(t, CredentialsOCM, settings.credentials())
+	assert.Equal(t, map[string]string{
+		"base_url":    "env:CRC_URL",
+		"token_url":   "ocm:" + path,
+		"credentials": "ocm:" + path,
+	}, settings.Sources)
+
+	// replays don't need the settings defaulted for them
+	settings, err = resolveConnectionSettings(withReplayDefaults(crcConfig{}))
+	assert.NoError(t, err)
+	settings.markReplayDefaults(crcConfig{})
+	assert.Equal(t, map[string]string{
+		"base_url":      "replay",
+		"token_url":     "replay",
+		"client_id":     "replay",
+		"client_secret": "replay",
+	}, settings.Sources)
+}
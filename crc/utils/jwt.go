@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// decodeJWTClaims returns the claims of a JWT. The signature is not verified:
+// the claims are only used to find out about tokens issued to the plugin.
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the JWT payload: %v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("error parsing the JWT claims: %v", err)
+	}
+
+	return claims, nil
+}
+
+// jwtExpiry returns the expiration time of a JWT
+func jwtExpiry(token string) (time.Time, error) {
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, errors.New("the JWT has no expiration time")
+	}
+
+	return time.Unix(int64(exp), 0), nil
+}
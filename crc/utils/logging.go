@@ -2,11 +2,136 @@ package utils
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/context_key"
 )
 
-// LogErrorUsingSteampipeLogger logs an error using the steampipe logger
+// LogErrorUsingSteampipeLogger logs an error using the steampipe logger and
+// records it in the span of the hydrate function
 func LogErrorUsingSteampipeLogger(ctx context.Context, table, errType string, err error) {
 	plugin.Logger(ctx).Error(table, errType, err)
+	recordError(ctx, err)
+}
+
+// redacted replaces the secrets in the logs
+const redacted = "REDACTED"
+
+// sensitiveHeaders are the headers whose values are never logged
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// sensitiveFormFields are the fields of the SSO token exchange whose values are never logged
+var sensitiveFormFields = []string{"client_secret", "refresh_token", "access_token", "id_token"}
+
+// logger returns the Steampipe logger of the context, or a logger discarding
+// everything when there is none, e.g. in the unit tests
+func logger(ctx context.Context) hclog.Logger {
+	if logger, ok := ctx.Value(context_key.Logger).(hclog.Logger); ok {
+		return logger
+	}
+	return hclog.NewNullLogger()
+}
+
+// RedactHeader returns a copy of the header without the values of the sensitive headers
+func RedactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	return header
+}
+
+// redactForm returns a copy of the form without the values of the sensitive fields
+func redactForm(form url.Values) url.Values {
+	redactedForm := url.Values{}
+	for name, values := range form {
+		redactedForm[name] = values
+	}
+	for _, name := range sensitiveFormFields {
+		if redactedForm.Get(name) != "" {
+			redactedForm.Set(name, redacted)
+		}
+	}
+	return redactedForm
+}
+
+// apiCallLog logs an outbound call at the debug level once it is done, so
+// that STEAMPIPE_LOG_LEVEL=debug or trace tells where the time of a query went
+type apiCallLog struct {
+	logger   hclog.Logger
+	method   string
+	endpoint string
+	start    time.Time
+	retries  int
+}
+
+// newAPICallLog starts timing the call
+func newAPICallLog(ctx context.Context, method, endpoint string) *apiCallLog {
+	return &apiCallLog{logger: logger(ctx), method: method, endpoint: endpoint, start: time.Now()}
+}
+
+// attempt logs, at the trace level, the request about to be sent
+func (l *apiCallLog) attempt(req *http.Request, attempt int) {
+	l.retries = attempt - 1
+	if l.logger.IsTrace() {
+		l.logger.Trace("sending API request", "method", l.method, "endpoint", l.endpoint, "attempt", attempt, "header", RedactHeader(req.Header))
+	}
+}
+
+// retry logs why the request is sent again and after how long
+func (l *apiCallLog) retry(reason string, delay time.Duration) {
+	l.logger.Debug("retrying API request", "method", l.method, "endpoint", l.endpoint, "reason", reason, "delay", delay, "retries", l.retries)
+}
+
+// failed logs the call which failed before getting a response
+func (l *apiCallLog) failed(err error) {
+	l.logger.Debug("API request failed", "method", l.method, "endpoint", l.endpoint, "duration", time.Since(l.start), "retries", l.retries, "error", err)
+}
+
+// done logs the call which got the response with the given body size
+func (l *apiCallLog) done(resp *http.Response, bytes int64) {
+	l.logger.Debug("API request done", "method", l.method, "endpoint", l.endpoint, "status", resp.StatusCode,
+		"bytes", bytes, "duration", time.Since(l.start), "retries", l.retries, "request_id", resp.Header.Get(RequestIDHeader))
+	if l.logger.IsTrace() {
+		l.logger.Trace("API response", "method", l.method, "endpoint", l.endpoint, "header", RedactHeader(resp.Header))
+	}
+}
+
+// doneWhenRead wraps the body of the response so that the call is logged once
+// the body is closed, with the number of bytes read and the total duration
+func (l *apiCallLog) doneWhenRead(resp *http.Response) {
+	if !l.logger.IsDebug() {
+		return
+	}
+	resp.Body = &loggedBody{ReadCloser: resp.Body, onClose: func(bytes int64) { l.done(resp, bytes) }}
+}
+
+// loggedBody counts the bytes read from a response body
+type loggedBody struct {
+	io.ReadCloser
+	bytes     int64
+	onClose   func(bytes int64)
+	closeOnce sync.Once
+}
+
+// Read implements the Reader interface
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+// Close implements the Closer interface
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.closeOnce.Do(func() { b.onClose(b.bytes) })
+	return err
 }
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/context_key"
+)
+
+// withTestLogger returns a context with a logger of the level writing into the buffer
+func withTestLogger(level hclog.Level, output *bytes.Buffer) context.Context {
+	logger := hclog.New(&hclog.LoggerOptions{Level: level, Output: output})
+	return context.WithValue(context.Background(), context_key.Logger, logger)
+}
+
+func TestRedaction(t *testing.T) {
+	header := http.Header{"Authorization": {"Bearer secret-token"}, "Content-Type": {"application/json"}}
+	assert.Equal(t, http.Header{"Authorization": {redacted}, "Content-Type": {"application/json"}}, RedactHeader(header))
+	assert.Equal(t, "Bearer secret-token", header.Get("Authorization"))
+
+	form := url.Values{"grant_type": {"refresh_token"}, "client_id": {"cloud-services"}, "refresh_token": {"secret-token"}}
+	assert.Equal(t, url.Values{"grant_type": {"refresh_token"}, "client_id": {"cloud-services"}, "refresh_token": {redacted}}, redactForm(form))
+	assert.Equal(t, "secret-token", form.Get("refresh_token"))
+}
+
+func TestAPIRequestsAreLogged(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 1, http.StatusServiceUnavailable, map[string]string{RequestIDHeader: "0123456789abcdef"}, &hits)
+
+	var output bytes.Buffer
+	ctx := withTestLogger(hclog.Debug, &output)
+	resp, err := doAPIRequest(ctx, server.Client(), http.MethodGet, server.URL+"/api/clusters", nil, nil, testRetryPolicy)
+	assert.NoError(t, err)
+
+	// the call is logged once its body is read
+	assert.NotContains(t, output.String(), "API request done: method=GET endpoint="+server.URL+"/api/clusters status=200")
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	logs := output.String()
+	assert.Contains(t, logs, "API request done: method=GET endpoint="+server.URL+"/api/clusters status=503 bytes=0")
+	assert.Contains(t, logs, "request_id=0123456789abcdef")
+	assert.Contains(t, logs, `retrying API request: method=GET endpoint=`+server.URL+`/api/clusters reason="503 Service Unavailable"`)
+	assert.Contains(t, logs, "API request done: method=GET endpoint="+server.URL+"/api/clusters status=200 bytes=12")
+	assert.Contains(t, logs, "retries=1")
+	assert.NotContains(t, logs, "sending API request")
+
+	// the requests themselves are only logged at the trace level
+	output.Reset()
+	ctx = withTestLogger(hclog.Trace, &output)
+	resp, err = doAPIRequest(ctx, server.Client(), http.MethodGet, server.URL, http.Header{"Authorization": {"Bearer secret-token"}}, nil, testRetryPolicy)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Contains(t, output.String(), "sending API request")
+	assert.NotContains(t, output.String(), "secret-token")
+}
+
+func TestTokenRequestsAreLoggedWithoutSecrets(t *testing.T) {
+	var issued int32
+	tokenServer := newTokenServer(t, &issued, 0)
+
+	var output bytes.Buffer
+	ctx := withTestLogger(hclog.Trace, &output)
+	for _, client := range []*SSOClient{
+		NewSSOClient("id", "client-secret", tokenServer.URL),
+		NewOfflineTokenSSOClient("offline-token", tokenServer.URL),
+	} {
+		_, err := client.accessToken(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+
+	logs := output.String()
+	assert.Contains(t, logs, "token request done: token_url="+tokenServer.URL+" grant_type=client_credentials status=200")
+	assert.Contains(t, logs, "token request done: token_url="+tokenServer.URL+" grant_type=refresh_token status=200")
+	for _, secret := range []string{"client-secret", "offline-token", "token-1", "token-2"} {
+		assert.NotContains(t, logs, secret)
+	}
+}
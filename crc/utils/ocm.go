@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultOCMTokenURL is the token URL used by the ocm CLI when its configuration doesn't set one
+const DefaultOCMTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// ocmTokenExpiryMargin renews the access tokens read from the ocm CLI
+// configuration slightly before they expire, as they may be close to it
+const ocmTokenExpiryMargin = 30 * time.Second
+
+// ocmConsoleURLs maps the OCM API gateways to the matching console.redhat.com environment
+var ocmConsoleURLs = map[string]string{
+	"https://api.openshift.com":       "https://console.redhat.com/",
+	"https://api.stage.openshift.com": "https://console.stage.redhat.com/",
+}
+
+// ocmConfig is the configuration file written by `ocm login`
+type ocmConfig struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenURL     string `json:"token_url,omitempty"`
+	URL          string `json:"url,omitempty"`
+
+	// path is the file the configuration was read from
+	path string
+}
+
+// ocmConfigPath returns the path of the ocm CLI configuration and whether it was explicitly set
+func ocmConfigPath(configPath *string) (string, bool, error) {
+	path := os.Getenv("OCM_CONFIG")
+	if configPath != nil && *configPath != "" {
+		path = *configPath
+	}
+	if path != "" {
+		path, err := expandHome(path)
+		return path, true, err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false, fmt.Errorf("error looking for the ocm configuration: %v", err)
+	}
+	return filepath.Join(home, ".config", "ocm", "ocm.json"), false, nil
+}
+
+// loadOCMConfig reads the ocm CLI configuration. A missing file is only an
+// error if its path was explicitly set.
+func loadOCMConfig(configPath *string) (*ocmConfig, error) {
+	path, explicit, err := ocmConfigPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the ocm configuration: %v", err)
+	}
+
+	var config ocmConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing the ocm configuration %s: %v", path, err)
+	}
+
+	if config.AccessToken == "" && config.RefreshToken == "" && config.ClientSecret == "" {
+		return nil, fmt.Errorf("the ocm configuration %s has no credentials, run `ocm login` first", path)
+	}
+
+	config.path = path
+	return &config, nil
+}
+
+// tokenURL returns the token URL used by the ocm CLI
+func (c *ocmConfig) tokenURL() string {
+	if c.TokenURL != "" {
+		return c.TokenURL
+	}
+	return DefaultOCMTokenURL
+}
+
+// consoleURL returns the console.redhat.com environment matching the OCM API
+// the ocm CLI is logged into, if it is a known one
+func (c *ocmConfig) consoleURL() string {
+	return ocmConsoleURLs[strings.TrimRight(c.URL, "/")]
+}
+
+// ssoClient returns an SSO client reusing the tokens stored by the ocm CLI.
+// Expired tokens are renewed with the stored refresh token, or the client
+// credentials if the ocm CLI is logged in with a service account.
+func (c *ocmConfig) ssoClient(tokenURL string) (*SSOClient, error) {
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = OfflineTokenClientID
+	}
+
+	ssoClient := &SSOClient{
+		ClientID:     clientID,
+		ClientSecret: c.ClientSecret,
+		RefreshToken: c.RefreshToken,
+		TokenURL:     tokenURL,
+		Transport:    http.DefaultTransport,
+	}
+
+	if c.AccessToken != "" {
+		expiry, err := jwtExpiry(c.AccessToken)
+		if err == nil {
+			ssoClient.Token = c.AccessToken
+			ssoClient.TokenExpiry = expiry.Add(-ocmTokenExpiryMargin)
+		}
+	}
+
+	if ssoClient.RefreshToken == "" && ssoClient.ClientSecret == "" && time.Now().After(ssoClient.TokenExpiry) {
+		return nil, errors.New("the access token of the ocm configuration has expired and it can't be refreshed, run `ocm login` again")
+	}
+
+	return ssoClient, nil
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeJWT returns an unsigned JWT with the given claims
+func fakeJWT(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".signature"
+}
+
+// writeOCMConfig writes an ocm CLI configuration in a temporary directory
+func writeOCMConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ocm.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// clearCredentialsEnv makes sure the environment doesn't hold any credentials
+func clearCredentialsEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CRC_URL", "CRC_TOKEN_URL", "CRC_CLIENT_ID", "CRC_CLIENT_SECRET", "CRC_OFFLINE_TOKEN", "OCM_CONFIG"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+}
+
+func TestOCMConfigRefreshesExpiredToken(t *testing.T) {
+	clearCredentialsEnv(t)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "ocm-cli", r.PostForm.Get("client_id"))
+		assert.Equal(t, "stored-refresh-token", r.PostForm.Get("refresh_token"))
+		fmt.Fprint(w, `{"access_token": "refreshed", "expires_in": 300}`)
+	}))
+	defer tokenServer.Close()
+
+	expired := fakeJWT(fmt.Sprintf(`{"exp": %d}`, time.Now().Add(-time.Hour).Unix()))
+	path := writeOCMConfig(t, fmt.Sprintf(`{
+		"access_token": %q,
+		"refresh_token": "stored-refresh-token",
+		"client_id": "ocm-cli",
+		"token_url": %q,
+		"url": "https://api.stage.openshift.com"
+	}`, expired, tokenServer.URL))
+
+	settings, err := resolveConnectionSettings(crcConfig{OCMConfigPath: &path})
+	assert.NoError(t, err)
+	assert.Equal(t, tokenServer.URL, settings.TokenURL)
+	assert.Equal(t, "https://console.stage.redhat.com/", settings.BaseURL)
+
+	ssoClient, err := settings.ssoClient()
+	assert.NoError(t, err)
+	token, err := ssoClient.accessToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed", token)
+}
+
+func TestOCMConfigReusesValidToken(t *testing.T) {
+	clearCredentialsEnv(t)
+
+	valid := fakeJWT(fmt.Sprintf(`{"exp": %d}`, time.Now().Add(time.Hour).Unix()))
+	path := writeOCMConfig(t, fmt.Sprintf(`{"access_token": %q, "refresh_token": "stored-refresh-token"}`, valid))
+	t.Setenv("OCM_CONFIG", path)
+	baseURL := "https://console.redhat.com/"
+
+	settings, err := resolveConnectionSettings(crcConfig{BaseUrl: &baseURL})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultOCMTokenURL, settings.TokenURL)
+
+	// the token is still valid, so the token endpoint is never called
+	ssoClient, err := settings.ssoClient()
+	assert.NoError(t, err)
+	token, err := ssoClient.accessToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, valid, token)
+}
+
+func TestOCMConfigPrecedence(t *testing.T) {
+	clearCredentialsEnv(t)
+
+	path := writeOCMConfig(t, `{"refresh_token": "stored-refresh-token"}`)
+	baseURL, tokenURL, clientID, clientSecret := "https://console.redhat.com/", "https://sso.example.com/token", "id", "secret"
+
+	// explicit credentials take precedence over the ocm configuration
+	settings, err := resolveConnectionSettings(crcConfig{BaseUrl: &baseURL, TokenURL: &tokenURL, ClientID: &clientID, ClientSecret: &clientSecret, OCMConfigPath: &path})
+	assert.NoError(t, err)
+	assert.Nil(t, settings.OCM)
+
+	t.Setenv("CRC_OFFLINE_TOKEN", "offline")
+	settings, err = resolveConnectionSettings(crcConfig{BaseUrl: &baseURL, OCMConfigPath: &path})
+	assert.ErrorContains(t, err, "token_url")
+	assert.Nil(t, settings.OCM)
+}
+
+func TestOCMConfigPath(t *testing.T) {
+	clearCredentialsEnv(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	path, explicit, err := ocmConfigPath(nil)
+	assert.NoError(t, err)
+	assert.False(t, explicit)
+	assert.Equal(t, filepath.Join(home, ".config", "ocm", "ocm.json"), path)
+
+	// a leading ~/ is the home directory, in the option and in OCM_CONFIG
+	option := "~/.config/ocm/ocm.json"
+	path, explicit, err = ocmConfigPath(&option)
+	assert.NoError(t, err)
+	assert.True(t, explicit)
+	assert.Equal(t, filepath.Join(home, ".config", "ocm", "ocm.json"), path)
+
+	t.Setenv("OCM_CONFIG", "~/ocm-stage.json")
+	path, _, err = ocmConfigPath(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "ocm-stage.json"), path)
+
+	relative := "ocm/~/ocm.json"
+	path, _, err = ocmConfigPath(&relative)
+	assert.NoError(t, err)
+	assert.Equal(t, relative, path)
+}
+
+func TestOCMConfigErrors(t *testing.T) {
+	clearCredentialsEnv(t)
+	baseURL := "https://console.redhat.com/"
+
+	// a missing file at the default location just means there are no credentials
+	settings, err := resolveConnectionSettings(crcConfig{BaseUrl: &baseURL, TokenURL: &baseURL})
+	assert.NoError(t, err)
+	_, err = settings.ssoClient()
+	assert.ErrorContains(t, err, "ocm CLI")
+
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	_, err = resolveConnectionSettings(crcConfig{BaseUrl: &baseURL, OCMConfigPath: &missing})
+	assert.ErrorContains(t, err, "error reading the ocm configuration")
+
+	loggedOut := writeOCMConfig(t, `{"url": "https://api.openshift.com"}`)
+	_, err = resolveConnectionSettings(crcConfig{OCMConfigPath: &loggedOut})
+	assert.ErrorContains(t, err, "ocm login")
+}
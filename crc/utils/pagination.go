@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// DefaultPageSize is the number of items requested per page from the APIs supporting limit/offset pagination
+const DefaultPageSize = 100
+
+// PageMeta holds the pagination metadata returned by the console.redhat.com services
+type PageMeta struct {
+	Count      int `json:"count,omitempty"`
+	Limit      int `json:"limit,omitempty"`
+	Offset     int `json:"offset,omitempty"`
+	TotalItems int `json:"total_items,omitempty"`
+}
+
+// PageLinks holds the navigation links returned by the console.redhat.com services
+type PageLinks struct {
+	First    string `json:"first,omitempty"`
+	Next     string `json:"next,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Last     string `json:"last,omitempty"`
+}
+
+// Page is the pagination envelope shared by the console.redhat.com services.
+// Response structs embed it next to their data.
+type Page struct {
+	Meta  PageMeta  `json:"meta"`
+	Links PageLinks `json:"links"`
+}
+
+// PageFunc decodes a single page, streams its items and returns its pagination
+// envelope. Returning a nil page stops the pagination.
+type PageFunc func(body io.ReadCloser) (*Page, error)
+
+// Paginate requests the endpoint page by page, passing each response body to
+// pageFunc, until there are no more pages or Steampipe doesn't need more rows.
+// A pageSize of 0 doesn't request any page size and just follows the links
+// returned by the API, if any.
+func Paginate(ctx context.Context, d *plugin.QueryData, table, endpoint string, pageSize int, timeout time.Duration, pageFunc PageFunc) error {
+	next := endpoint
+	if pageSize > 0 {
+		var err error
+		next, err = withPagination(endpoint, pageSize, 0)
+		if err != nil {
+			LogErrorUsingSteampipeLogger(ctx, table, "query_error", err)
+			return err
+		}
+	}
+
+	visited := map[string]bool{}
+	for next != "" && !visited[next] {
+		// Steampipe already waited for the rate limiters before the first page
+		if len(visited) > 0 {
+			d.WaitForListRateLimit(ctx)
+		}
+		visited[next] = true
+
+		resp, err := MakeAPIRequest(ctx, d, "GET", next, nil, timeout)
+		if err != nil {
+			LogErrorUsingSteampipeLogger(ctx, table, "api_error", err)
+			return err
+		}
+
+		page, err := pageFunc(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			LogErrorUsingSteampipeLogger(ctx, table, "decode_error", err)
+			return err
+		}
+
+		// stop if the query doesn't need more rows (e.g. because of a LIMIT)
+		if RowsRemaining(ctx, d) == 0 {
+			return nil
+		}
+
+		next, err = nextPageEndpoint(next, page)
+		if err != nil {
+			LogErrorUsingSteampipeLogger(ctx, table, "api_error", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nextPageEndpoint returns the endpoint of the page following the given one,
+// or an empty string if it was the last page
+func nextPageEndpoint(current string, page *Page) (string, error) {
+	if page == nil {
+		return "", nil
+	}
+
+	if page.Links.Next != "" {
+		return relativeEndpoint(page.Links.Next)
+	}
+
+	meta := page.Meta
+	if meta.Limit > 0 && meta.TotalItems > meta.Offset+meta.Limit {
+		return withPagination(current, meta.Limit, meta.Offset+meta.Limit)
+	}
+
+	return "", nil
+}
+
+// withPagination sets the limit and offset query parameters of the endpoint
+func withPagination(endpoint string, limit, offset int) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("error parsing endpoint %q: %v", endpoint, err)
+	}
+
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// relativeEndpoint converts a link returned by the API, either absolute or
+// relative to the host, into an endpoint relative to the base URL
+func relativeEndpoint(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("error parsing link %q: %v", link, err)
+	}
+
+	endpoint := strings.TrimPrefix(u.Path, "/")
+	if u.RawQuery != "" {
+		endpoint += "?" + u.RawQuery
+	}
+
+	return endpoint, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextPageEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		page     *Page
+		expected string
+	}{
+		{
+			name:     "no envelope",
+			current:  "api/gathering/v1/gathering_rules",
+			page:     nil,
+			expected: "",
+		},
+		{
+			name:     "next link relative to the host",
+			current:  "api/ocp-vulnerability/v1/cves?limit=10&offset=0",
+			page:     &Page{Links: PageLinks{Next: "/api/ocp-vulnerability/v1/cves?limit=10&offset=10"}},
+			expected: "api/ocp-vulnerability/v1/cves?limit=10&offset=10",
+		},
+		{
+			name:     "absolute next link",
+			current:  "api/ocp-vulnerability/v1/cves?limit=10&offset=0",
+			page:     &Page{Links: PageLinks{Next: "https://console.redhat.com/api/ocp-vulnerability/v1/cves?limit=10&offset=10"}},
+			expected: "api/ocp-vulnerability/v1/cves?limit=10&offset=10",
+		},
+		{
+			name:     "more items according to meta",
+			current:  "api/ocp-vulnerability/v1/clusters?limit=10&offset=0",
+			page:     &Page{Meta: PageMeta{Limit: 10, Offset: 0, TotalItems: 25}},
+			expected: "api/ocp-vulnerability/v1/clusters?limit=10&offset=10",
+		},
+		{
+			name:     "last page according to meta",
+			current:  "api/ocp-vulnerability/v1/clusters?limit=10&offset=20",
+			page:     &Page{Meta: PageMeta{Limit: 10, Offset: 20, TotalItems: 25}},
+			expected: "",
+		},
+		{
+			name:     "count only",
+			current:  "api/insights-results-aggregator/v2/clusters",
+			page:     &Page{Meta: PageMeta{Count: 3}},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := nextPageEndpoint(tt.current, tt.page)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, next)
+		})
+	}
+}
+
+func TestWithPagination(t *testing.T) {
+	endpoint, err := withPagination("api/ocp-vulnerability/v1/cves?sort=-publish_date", 100, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, "api/ocp-vulnerability/v1/cves?limit=100&offset=200&sort=-publish_date", endpoint)
+}
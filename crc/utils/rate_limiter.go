@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/turbot/steampipe-plugin-sdk/v5/rate_limiter"
+	"golang.org/x/time/rate"
+)
+
+// ServiceTag is the table tag naming the console.redhat.com service a table queries
+const ServiceTag = "service"
+
+// The console.redhat.com API families queried by the plugin
+const (
+	ServiceAggregator       = "aggregator"
+	ServiceOCPVulnerability = "ocp-vulnerability"
+	ServiceGathering        = "gathering"
+	ServiceEntitlements     = "entitlements"
+)
+
+// RateLimit is the fill rate (requests per second) and bucket size of a rate limiter
+type RateLimit struct {
+	FillRate   float64
+	BucketSize int64
+}
+
+// DefaultRateLimits are the rate limits of each service, per connection
+var DefaultRateLimits = map[string]RateLimit{
+	ServiceAggregator:       {FillRate: 5, BucketSize: 10},
+	ServiceOCPVulnerability: {FillRate: 10, BucketSize: 20},
+	ServiceGathering:        {FillRate: 10, BucketSize: 20},
+}
+
+// DefaultServiceRateLimit applies to the services not listed in DefaultRateLimits
+var DefaultServiceRateLimit = RateLimit{FillRate: 5, BucketSize: 10}
+
+// tableService returns the service queried by the table, if any
+func tableService(d *plugin.QueryData) string {
+	if d.Table == nil {
+		return ""
+	}
+	return d.Table.Tags[ServiceTag]
+}
+
+// ServiceTags returns the table tags used to select the rate limiter of the service
+func ServiceTags(service string) map[string]string {
+	return map[string]string{ServiceTag: service}
+}
+
+// RateLimiters returns the plugin rate limiters: one per service and a
+// fallback one for any other service. Each connection gets its own limiters.
+func RateLimiters() []*rate_limiter.Definition {
+	services := make([]string, 0, len(DefaultRateLimits))
+	for service := range DefaultRateLimits {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var definitions []*rate_limiter.Definition
+	for _, service := range services {
+		limit := DefaultRateLimits[service]
+		definitions = append(definitions, &rate_limiter.Definition{
+			Name:       "crc_" + strings.ReplaceAll(service, "-", "_"),
+			FillRate:   rate.Limit(limit.FillRate),
+			BucketSize: limit.BucketSize,
+			Scope:      []string{rate_limiter.RateLimiterScopeConnection, ServiceTag},
+			Where:      fmt.Sprintf("%s = '%s'", ServiceTag, service),
+		})
+	}
+
+	quoted := make([]string, len(services))
+	for i, service := range services {
+		quoted[i] = fmt.Sprintf("'%s'", service)
+	}
+	definitions = append(definitions, &rate_limiter.Definition{
+		Name:       "crc_default",
+		FillRate:   rate.Limit(DefaultServiceRateLimit.FillRate),
+		BucketSize: DefaultServiceRateLimit.BucketSize,
+		Scope:      []string{rate_limiter.RateLimiterScopeConnection, ServiceTag},
+		Where:      fmt.Sprintf("%s not in (%s)", ServiceTag, strings.Join(quoted, ", ")),
+	})
+
+	return definitions
+}
+
+// connectionLimitersMu avoids creating two limiters for the same connection and service
+var connectionLimitersMu sync.Mutex
+
+// waitForConnectionRateLimit blocks until the rate limit set in the
+// connection configuration for the service of the queried table allows
+// another request. It is applied on top of the plugin rate limiters.
+func waitForConnectionRateLimit(ctx context.Context, d *plugin.QueryData) error {
+	if d.Table == nil {
+		return nil
+	}
+	service := d.Table.Tags[ServiceTag]
+
+	config, err := loadConfig(d.Connection)
+	if err != nil {
+		return err
+	}
+	var limit *rateLimitConfig
+	for _, rateLimit := range config.RateLimits {
+		if rateLimit.Service == service {
+			limit = &rateLimit
+			break
+		}
+	}
+	if limit == nil {
+		return nil
+	}
+
+	defaults, ok := DefaultRateLimits[service]
+	if !ok {
+		defaults = DefaultServiceRateLimit
+	}
+	fillRate, bucketSize := defaults.FillRate, int(defaults.BucketSize)
+	if limit.FillRate != nil {
+		fillRate = *limit.FillRate
+	}
+	if limit.BucketSize != nil {
+		bucketSize = *limit.BucketSize
+	}
+
+	// The configured values are part of the key, so an updated configuration
+	// gets a new limiter instead of the one of the previous configuration
+	cacheKey := fmt.Sprintf("crc_rate_limiter_%s_%v_%d", service, fillRate, bucketSize)
+	var limiter *rate.Limiter
+	connectionLimitersMu.Lock()
+	if cachedData, ok := d.ConnectionManager.Cache.Get(cacheKey); ok {
+		limiter = cachedData.(*rate.Limiter)
+	} else {
+		limiter = rate.NewLimiter(rate.Limit(fillRate), bucketSize)
+		d.ConnectionManager.Cache.Set(cacheKey, limiter)
+	}
+	connectionLimitersMu.Unlock()
+
+	return limiter.Wait(ctx)
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiters(t *testing.T) {
+	definitions := RateLimiters()
+	assert.Len(t, definitions, len(DefaultRateLimits)+1)
+
+	names := map[string]bool{}
+	for _, definition := range definitions {
+		assert.NoError(t, definition.Initialise(), definition.Name)
+		assert.False(t, names[definition.Name], "duplicated limiter %s", definition.Name)
+		names[definition.Name] = true
+	}
+	assert.True(t, names["crc_ocp_vulnerability"])
+	assert.Equal(t, "service not in ('aggregator', 'gathering', 'ocp-vulnerability')", definitions[len(definitions)-1].Where)
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how failed API requests are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on each retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts
+	MaxDelay time.Duration
+	// Jitter randomizes the delays so that parallel requests don't retry in lockstep
+	Jitter bool
+}
+
+// DefaultRetryPolicy is used for the settings missing in the connection configuration
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Jitter:      true,
+}
+
+// retryPolicyFromConfig builds the retry policy of the connection
+func retryPolicyFromConfig(config crcConfig) (RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+
+	if config.MaxAttempts != nil {
+		if *config.MaxAttempts < 1 {
+			return policy, fmt.Errorf("'max_attempts' must be at least 1, got %d", *config.MaxAttempts)
+		}
+		policy.MaxAttempts = *config.MaxAttempts
+	}
+	if config.RetryBaseDelay != nil {
+		delay, err := time.ParseDuration(*config.RetryBaseDelay)
+		if err != nil {
+			return policy, fmt.Errorf("'retry_base_delay' is not a valid duration: %v", err)
+		}
+		policy.BaseDelay = delay
+	}
+	if config.RetryMaxDelay != nil {
+		delay, err := time.ParseDuration(*config.RetryMaxDelay)
+		if err != nil {
+			return policy, fmt.Errorf("'retry_max_delay' is not a valid duration: %v", err)
+		}
+		policy.MaxDelay = delay
+	}
+	if config.RetryJitter != nil {
+		policy.Jitter = *config.RetryJitter
+	}
+
+	return policy, nil
+}
+
+// backoff returns the delay to wait after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter && delay > 0 {
+		// keep at least half of the delay so that the backoff still grows
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	return delay
+}
+
+// isIdempotent reports whether requests with the given method can be safely retried
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryableStatus reports whether the status code denotes a transient failure
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryableError reports whether the error is caused by a dropped connection
+func isRetryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// sleep waits for the given delay unless the context is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    time.Second,
+	Jitter:      true,
+}
+
+// newFlakyServer returns a server that fails the first `failures` requests
+// with the given status code and headers
+func newFlakyServer(t *testing.T, failures int32, statusCode int, headers map[string]string, hits *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(hits, 1) <= failures {
+			for key, value := range headers {
+				w.Header().Set(key, value)
+			}
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRetryTransientStatusCodes(t *testing.T) {
+	for _, statusCode := range []int{429, 502, 503, 504} {
+		var hits int32
+		server := newFlakyServer(t, 2, statusCode, nil, &hits)
+
+		resp, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+		if assert.NoError(t, err, "status code %d", statusCode) {
+			resp.Body.Close()
+		}
+		assert.Equal(t, int32(3), atomic.LoadInt32(&hits), "status code %d", statusCode)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 5, http.StatusServiceUnavailable, nil, &hits)
+
+	_, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+	assert.ErrorContains(t, err, "status code 503")
+	assert.Equal(t, int32(testRetryPolicy.MaxAttempts), atomic.LoadInt32(&hits))
+}
+
+func TestRetryNotRetryableStatusCode(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 1, http.StatusInternalServerError, nil, &hits)
+
+	_, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+	assert.ErrorContains(t, err, "status code 500")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestRetryOnlyIdempotentMethods(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 1, http.StatusServiceUnavailable, nil, &hits)
+
+	_, err := doAPIRequest(context.Background(), server.Client(), "POST", server.URL, nil, map[string]string{"a": "b"}, testRetryPolicy)
+	assert.ErrorContains(t, err, "status code 503")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 1, http.StatusTooManyRequests, map[string]string{"Retry-After": "1"}, &hits)
+
+	start := time.Now()
+	resp, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestRetryAfterLongerThanMaxDelay(t *testing.T) {
+	var hits int32
+	server := newFlakyServer(t, 1, http.StatusTooManyRequests, map[string]string{"Retry-After": "120"}, &hits)
+
+	_, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+	assert.ErrorContains(t, err, "status code 429")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestRetryConnectionReset(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if assert.NoError(t, err) {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	resp, err := doAPIRequest(context.Background(), server.Client(), "GET", server.URL, nil, nil, testRetryPolicy)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, time.Second, policy.backoff(10))
+
+	policy.Jitter = true
+	for attempt := 1; attempt < 10; attempt++ {
+		delay := policy.backoff(attempt)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, time.Second)
+	}
+}
+
+func TestRetryPolicyFromConfig(t *testing.T) {
+	attempts := 5
+	baseDelay := "250ms"
+	jitter := false
+	policy, err := retryPolicyFromConfig(crcConfig{MaxAttempts: &attempts, RetryBaseDelay: &baseDelay, RetryJitter: &jitter})
+	assert.NoError(t, err)
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, BaseDelay: 250 * time.Millisecond, MaxDelay: DefaultRetryPolicy.MaxDelay}, policy)
+
+	invalid := "soon"
+	_, err = retryPolicyFromConfig(crcConfig{RetryMaxDelay: &invalid})
+	assert.ErrorContains(t, err, "retry_max_delay")
+
+	zero := 0
+	_, err = retryPolicyFromConfig(crcConfig{MaxAttempts: &zero})
+	assert.ErrorContains(t, err, "max_attempts")
+}
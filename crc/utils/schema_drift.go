@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// The kinds of schema drift
+const (
+	// SchemaDriftUnknown is a field returned by the API but not declared by the response struct
+	SchemaDriftUnknown = "unknown"
+	// SchemaDriftMissing is a field declared by the response struct, without
+	// omitempty, but not returned by the API
+	SchemaDriftMissing = "missing"
+)
+
+// SchemaDrift is a difference between the responses of an endpoint and the
+// struct they are decoded into, found by the strict decode mode
+type SchemaDrift struct {
+	Table    string
+	Endpoint string
+	// Field is the path of the field in the decoded value, e.g.
+	// "extra_data.error_key", with "[]" for the elements of arrays and "*" for
+	// the values of objects
+	Field       string
+	Kind        string
+	Occurrences int64
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// schemaDriftKey identifies the drifts of a connection
+type schemaDriftKey struct {
+	connection, table, endpoint, field, kind string
+}
+
+// schemaDrifts are the drifts found since the plugin started
+var (
+	schemaDrifts   = map[schemaDriftKey]*SchemaDrift{}
+	schemaDriftsMu sync.Mutex
+)
+
+// SchemaDrifts returns the drifts found in the responses of the connection
+func SchemaDrifts(connection string) []SchemaDrift {
+	schemaDriftsMu.Lock()
+	defer schemaDriftsMu.Unlock()
+
+	var drifts []SchemaDrift
+	for key, drift := range schemaDrifts {
+		if key.connection == connection {
+			drifts = append(drifts, *drift)
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		a, b := drifts[i], drifts[j]
+		return a.Table+a.Endpoint+a.Field+a.Kind < b.Table+b.Endpoint+b.Field+b.Kind
+	})
+	return drifts
+}
+
+// recordSchemaDrift records the drift, logging it the first time it is found
+func recordSchemaDrift(connection, table, endpoint, field, kind string) {
+	schemaDriftsMu.Lock()
+	defer schemaDriftsMu.Unlock()
+
+	key := schemaDriftKey{connection, table, endpoint, field, kind}
+	now := time.Now()
+	drift, ok := schemaDrifts[key]
+	if !ok {
+		log.Printf("[WARN] schema drift in the responses of %s for the table %s: %s field %q", endpoint, table, kind, field)
+		drift = &SchemaDrift{Table: table, Endpoint: endpoint, Field: field, Kind: kind, FirstSeen: now}
+		schemaDrifts[key] = drift
+	}
+	drift.Occurrences++
+	drift.LastSeen = now
+}
+
+// strictDecode reports whether the responses of the connection are checked for schema drift
+func strictDecode(d *plugin.QueryData) bool {
+	if d == nil {
+		return false
+	}
+	config := GetConfig(d.Connection)
+	return config.StrictDecode != nil && *config.StrictDecode
+}
+
+// responseBody is the body of a response returned by MakeAPIRequest, which
+// tells the decoders the endpoint it comes from
+type responseBody struct {
+	io.ReadCloser
+	endpoint string
+}
+
+// responseEndpoint returns the endpoint template the body was requested from, if known
+func responseEndpoint(body io.Reader) string {
+	if body, ok := body.(*responseBody); ok {
+		return body.endpoint
+	}
+	return ""
+}
+
+// DecodeJSON decodes the JSON body into v. In strict decode mode, the fields
+// differing from the ones declared by v are recorded as schema drift of the
+// endpoint the body was requested from.
+func DecodeJSON(d *plugin.QueryData, body io.Reader, v interface{}) error {
+	if !strictDecode(d) {
+		return json.NewDecoder(body).Decode(v)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return err
+	}
+	checkSchema(d, responseEndpoint(body), raw, reflect.TypeOf(v), "")
+	return json.Unmarshal(raw, v)
+}
+
+// checkSchema records the fields of the raw JSON value differing from the ones
+// declared by the type, the value being at the given path of the response
+func checkSchema(d *plugin.QueryData, endpoint string, raw []byte, t reflect.Type, path string) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		// the decoding of the value reports the error
+		return
+	}
+
+	var connection string
+	if d.Connection != nil {
+		connection = d.Connection.Name
+	}
+	table := tableName(d)
+	for _, drift := range schemaDiff(value, t, path) {
+		recordSchemaDrift(connection, table, endpoint, drift.field, drift.kind)
+	}
+}
+
+// fieldDrift is a field differing between a JSON value and a type
+type fieldDrift struct {
+	field, kind string
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textType        = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// schemaDiff returns the fields of the decoded JSON value differing from the
+// ones declared by the type, each of them once
+func schemaDiff(value interface{}, t reflect.Type, path string) []fieldDrift {
+	seen := map[fieldDrift]bool{}
+	var drifts []fieldDrift
+	var walk func(value interface{}, t reflect.Type, path string)
+	report := func(field, kind string) {
+		drift := fieldDrift{field, kind}
+		if !seen[drift] {
+			seen[drift] = true
+			drifts = append(drifts, drift)
+		}
+	}
+
+	walk = func(value interface{}, t reflect.Type, path string) {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if value == nil || t == timeType || reflect.PointerTo(t).Implements(unmarshalerType) || reflect.PointerTo(t).Implements(textType) {
+			return
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return
+			}
+			declared := map[string]bool{}
+			for _, field := range jsonFields(t) {
+				declared[field.name] = true
+				fieldValue, present := lookupField(object, field.name)
+				if !present {
+					if !field.omitempty {
+						report(joinPath(path, field.name), SchemaDriftMissing)
+					}
+					continue
+				}
+				walk(fieldValue, field.typ, joinPath(path, field.name))
+			}
+			for key := range object {
+				if !declaredField(declared, key) {
+					report(joinPath(path, key), SchemaDriftUnknown)
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			if items, ok := value.([]interface{}); ok {
+				for _, item := range items {
+					walk(item, t.Elem(), path+"[]")
+				}
+			}
+		case reflect.Map:
+			if object, ok := value.(map[string]interface{}); ok {
+				for _, item := range object {
+					walk(item, t.Elem(), joinPath(path, "*"))
+				}
+			}
+		}
+	}
+
+	walk(value, t, path)
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].field+drifts[i].kind < drifts[j].field+drifts[j].kind
+	})
+	return drifts
+}
+
+// jsonField is a field of a struct as seen by encoding/json
+type jsonField struct {
+	name      string
+	typ       reflect.Type
+	omitempty bool
+}
+
+// jsonFields returns the fields of the struct decoded by encoding/json,
+// including the ones of its embedded structs
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{name: name, typ: field.Type, omitempty: strings.Contains(options, "omitempty")})
+	}
+	return fields
+}
+
+// lookupField returns the value of the field, whose name is matched case
+// insensitively like encoding/json does
+func lookupField(object map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := object[name]; ok {
+		return value, true
+	}
+	for key, value := range object {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// declaredField reports whether the key matches a declared field
+func declaredField(declared map[string]bool, key string) bool {
+	if declared[key] {
+		return true
+	}
+	for name := range declared {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinPath appends the field to the path
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
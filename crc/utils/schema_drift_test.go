@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type driftedItem struct {
+	ID        string    `json:"id"`
+	ClusterID string    `json:"cluster_id,omitempty"` // added manually
+	CreatedAt time.Time `json:"created_at"`
+	Extra     struct {
+		Key  string   `json:"key"`
+		Tags []string `json:"tags"`
+	} `json:"extra"`
+	Rules   []struct{ Name string }    `json:"rules"`
+	Details interface{}                `json:"details"`
+	Counts  map[string]struct{ N int } `json:"counts"`
+	ignored string
+}
+
+type driftedResponse struct {
+	Data []driftedItem `json:"data"`
+	Page
+}
+
+func TestSchemaDiff(t *testing.T) {
+	body := `{
+		"data": [
+			{
+				"id": "a",
+				"created_at": "2024-05-13T08:51:23Z",
+				"extra": {"key": "k", "new": true},
+				"rules": [{"name": "r1"}, {"Name": "r2", "severity": 3}],
+				"details": {"anything": 1},
+				"counts": {"x": {"N": 1, "M": 2}},
+				"added": 1
+			},
+			{"id": "b", "extra": null, "rules": null, "details": null, "counts": {}, "added": 2}
+		],
+		"meta": {"count": 2},
+		"links": {},
+		"status": "ok"
+	}`
+	var value interface{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &value))
+
+	assert.Equal(t, []fieldDrift{
+		{"data[].added", SchemaDriftUnknown},
+		{"data[].counts.*.M", SchemaDriftUnknown},
+		{"data[].created_at", SchemaDriftMissing},
+		{"data[].extra.new", SchemaDriftUnknown},
+		{"data[].extra.tags", SchemaDriftMissing},
+		{"data[].rules[].severity", SchemaDriftUnknown},
+		{"status", SchemaDriftUnknown},
+	}, schemaDiff(value, reflectTypeOf[driftedResponse](), ""))
+}
+
+func TestSchemaDiffWithoutDrift(t *testing.T) {
+	body := `{"id": "a", "created_at": "2024-05-13T08:51:23Z", "extra": {"key": "k", "tags": []}, "rules": [], "details": 1, "counts": null}`
+	var value interface{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &value))
+	assert.Empty(t, schemaDiff(value, reflectTypeOf[*driftedItem](), "data[]"))
+}
+
+// reflectTypeOf returns the type T
+func reflectTypeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var rules struct {
+		Version string        `json:"version"`
+		Rules   []interface{} `json:"rules"`
+	}
+	err := DecodeJSON(nil, strings.NewReader(`{"version": "1.0.1", "rules": [{}, {}], "unknown": true}`), &rules)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.1", rules.Version)
+	assert.Len(t, rules.Rules, 2)
+
+	err = DecodeJSON(nil, strings.NewReader(`{"version": 1}`), &rules)
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// StreamArray decodes the array found at the given path of nested objects,
+// e.g. ["data"] or ["report", "data"], element by element, passing each one
+// to streamFunc as soon as it is decoded, and stops reading the body once
+// Steampipe doesn't need more rows. It returns the pagination envelope of the
+// response, which is incomplete if it follows the array and the reading
+// stopped early. In strict decode mode, the fields
+// of the elements differing from the ones declared by T are recorded as
+// schema drift.
+func StreamArray[T any](ctx context.Context, d *plugin.QueryData, body io.Reader, path []string, streamFunc func(item T)) (*Page, error) {
+	var check func(raw json.RawMessage)
+	if strictDecode(d) {
+		endpoint, itemPath, itemType := responseEndpoint(body), strings.Join(path, ".")+"[]", reflect.TypeOf((*T)(nil)).Elem()
+		check = func(raw json.RawMessage) {
+			checkSchema(d, endpoint, raw, itemType, itemPath)
+		}
+	}
+
+	return streamArray(body, path, streamFunc, check, func() bool {
+		return RowsRemaining(ctx, d) == 0
+	})
+}
+
+// streamArray implements StreamArray, passing each element to check, if not
+// nil, and calling done after each element to know whether to stop
+func streamArray[T any](body io.Reader, path []string, streamFunc func(item T), check func(raw json.RawMessage), done func() bool) (*Page, error) {
+	decoder := json.NewDecoder(body)
+	page := &Page{}
+
+	_, err := walkObject(decoder, path, page, func() (bool, error) {
+		var item T
+		if check == nil {
+			if err := decoder.Decode(&item); err != nil {
+				return false, err
+			}
+		} else {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return false, err
+			}
+			check(raw)
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return false, err
+			}
+		}
+		streamFunc(item)
+		return done(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// walkObject reads the object at the decoder position, walking into the array
+// at the path and calling decodeItem for each of its elements until it
+// returns true. The pagination envelope of the object is decoded into page,
+// if not nil. It returns whether decodeItem stopped the walk.
+func walkObject(decoder *json.Decoder, path []string, page *Page, decodeItem func() (bool, error)) (bool, error) {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return false, err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		key, _ := token.(string)
+
+		switch {
+		case key == path[0] && len(path) == 1:
+			stopped, err := walkArray(decoder, decodeItem)
+			if stopped || err != nil {
+				return stopped, err
+			}
+		case key == path[0]:
+			stopped, err := walkObject(decoder, path[1:], nil, decodeItem)
+			if stopped || err != nil {
+				return stopped, err
+			}
+		case page != nil && key == "meta":
+			err = decoder.Decode(&page.Meta)
+		case page != nil && key == "links":
+			err = decoder.Decode(&page.Links)
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, expectDelim(decoder, '}')
+}
+
+// walkArray reads the array at the decoder position, calling decodeItem for
+// each of its elements until it returns true. A null array is empty.
+func walkArray(decoder *json.Decoder, decodeItem func() (bool, error)) (bool, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return false, err
+	}
+	if token == nil {
+		return false, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return false, fmt.Errorf("expected a JSON array, got %v", token)
+	}
+
+	for decoder.More() {
+		stopped, err := decodeItem()
+		if stopped || err != nil {
+			return stopped, err
+		}
+	}
+
+	return false, expectDelim(decoder, ']')
+}
+
+// expectDelim reads the next token, which must be the delimiter
+func expectDelim(decoder *json.Decoder, expected json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return fmt.Errorf("expected %q in the JSON body, got %v", expected, token)
+	}
+	return nil
+}
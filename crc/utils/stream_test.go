@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type streamedItem struct {
+	ID string `json:"id"`
+}
+
+// collect returns a stream function appending the items to the slice
+func collect(items *[]string) func(streamedItem) {
+	return func(item streamedItem) {
+		*items = append(*items, item.ID)
+	}
+}
+
+func TestStreamArrayDecodesEveryItemAndThePage(t *testing.T) {
+	body := `{
+		"data": [{"id": "a", "extra": {"nested": [1, 2]}}, {"id": "b"}],
+		"meta": {"limit": 2, "offset": 0, "total_items": 5},
+		"links": {"next": "/api/things?limit=2&offset=2"},
+		"status": "ok"
+	}`
+
+	var items []string
+	page, err := streamArray(strings.NewReader(body), []string{"data"}, collect(&items), nil, func() bool { return false })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, items)
+	assert.Equal(t, PageMeta{Limit: 2, TotalItems: 5}, page.Meta)
+	assert.Equal(t, "/api/things?limit=2&offset=2", page.Links.Next)
+}
+
+func TestStreamArrayStopsReading(t *testing.T) {
+	// the body is broken after the second item, which is never read
+	body := `{"data": [{"id": "a"}, {"id": "b"}, {"id": BROKEN`
+
+	var items []string
+	_, err := streamArray(strings.NewReader(body), []string{"data"}, collect(&items), nil, func() bool { return len(items) == 2 })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, items)
+
+	_, err = streamArray(strings.NewReader(body), []string{"data"}, collect(&items), nil, func() bool { return false })
+	assert.Error(t, err)
+}
+
+func TestStreamArrayNestedPath(t *testing.T) {
+	body := `{"report": {"meta": {"count": 1}, "data": [{"id": "a"}]}, "data": [{"id": "ignored"}], "status": "ok"}`
+
+	var items []string
+	_, err := streamArray(strings.NewReader(body), []string{"report", "data"}, collect(&items), nil, func() bool { return false })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, items)
+}
+
+func TestStreamArrayEmptyAndInvalidData(t *testing.T) {
+	var items []string
+	for _, body := range []string{`{}`, `{"data": null}`, `{"data": []}`} {
+		_, err := streamArray(strings.NewReader(body), []string{"data"}, collect(&items), nil, func() bool { return false })
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, items)
+
+	_, err := streamArray(strings.NewReader(`{"data": {"id": "a"}}`), []string{"data"}, collect(&items), nil, func() bool { return false })
+	assert.ErrorContains(t, err, "expected a JSON array")
+
+	_, err = streamArray(strings.NewReader(`[]`), []string{"data"}, collect(&items), nil, func() bool { return false })
+	assert.ErrorContains(t, err, "expected")
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MaxAdaptiveTimeout bounds the timeouts raised by 'adaptive_timeout'
+const MaxAdaptiveTimeout = 5 * time.Minute
+
+// adaptiveTimeoutFactor is how many times the average latency of a service
+// its requests are given before timing out, with 'adaptive_timeout'
+const adaptiveTimeoutFactor = 4
+
+// timeouts are the timeouts of the requests of a connection
+type timeouts struct {
+	// global replaces the default timeout of the endpoints, if not zero
+	global time.Duration
+	// services replace the timeout of the requests to each service
+	services map[string]time.Duration
+	// latencies is set when the timeouts adapt to the observed latencies
+	latencies *latencyTracker
+}
+
+// timeoutsFromConfig returns the timeouts set by 'timeout', 'timeouts' and
+// 'adaptive_timeout' in the connection configuration
+func timeoutsFromConfig(config crcConfig) (*timeouts, error) {
+	t := &timeouts{services: map[string]time.Duration{}}
+	if config.Timeout != nil {
+		timeout, err := time.ParseDuration(*config.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("'timeout' must be a positive duration, got %q", *config.Timeout)
+		}
+		t.global = timeout
+	}
+	if config.Timeouts != nil {
+		for service, value := range *config.Timeouts {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("'timeouts' of service %q must be a positive duration, got %q", service, value)
+			}
+			t.services[service] = timeout
+		}
+	}
+	if config.AdaptiveTimeout != nil && *config.AdaptiveTimeout {
+		t.latencies = &latencyTracker{averages: map[string]time.Duration{}}
+	}
+	return t, nil
+}
+
+// timeout returns the timeout of a request to the service, whose endpoint
+// times out after defaultTimeout unless the connection configuration
+// overrides it. The timeout of the service takes precedence over the global
+// one. With 'adaptive_timeout', the timeout is raised to a multiple of the
+// average latency of the service, up to MaxAdaptiveTimeout.
+func (t *timeouts) timeout(service string, defaultTimeout time.Duration) time.Duration {
+	timeout := defaultTimeout
+	if t.global != 0 {
+		timeout = t.global
+	}
+	if serviceTimeout, ok := t.services[service]; ok {
+		timeout = serviceTimeout
+	}
+	if t.latencies != nil {
+		if average, ok := t.latencies.average(service); ok {
+			adaptive := min(adaptiveTimeoutFactor*average, MaxAdaptiveTimeout)
+			timeout = max(timeout, adaptive)
+		}
+	}
+	return timeout
+}
+
+// observe records the latency of a request to the service, if the timeouts
+// adapt to them. A request that timed out counts as lasting its timeout, so
+// that the timeout of the next requests grows.
+func (t *timeouts) observe(service string, latency time.Duration) {
+	if t.latencies != nil {
+		t.latencies.observe(service, latency)
+	}
+}
+
+// latencyTracker keeps the moving average of the latency of the requests to each service
+type latencyTracker struct {
+	mu       sync.Mutex
+	averages map[string]time.Duration
+}
+
+// latencyWeight is the weight of the last latency in the moving average
+const latencyWeight = 0.2
+
+// observe adds the latency to the moving average of the service
+func (l *latencyTracker) observe(service string, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	average, ok := l.averages[service]
+	if !ok {
+		l.averages[service] = latency
+		return
+	}
+	l.averages[service] = average + time.Duration(latencyWeight*float64(latency-average))
+}
+
+// average returns the moving average of the latency of the service, if any request was observed
+func (l *latencyTracker) average(service string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	average, ok := l.averages[service]
+	return average, ok
+}
+
+// timedBody calls onDone with the latency of the request once its body is
+// read or closed: the time until the response headers arrived plus the time
+// spent reading the body. The time the caller spends between the reads, e.g.
+// streaming the rows to Steampipe, isn't the latency of the service.
+type timedBody struct {
+	io.ReadCloser
+	// latency is the time until the response headers arrived, to which the
+	// reads are added
+	latency  time.Duration
+	onDone   func(latency time.Duration)
+	doneOnce sync.Once
+}
+
+// Read implements the Reader interface
+func (b *timedBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.latency += time.Since(start)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+// Close implements the Closer interface
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// done reports the latency, once
+func (b *timedBody) done() {
+	b.doneOnce.Do(func() { b.onDone(b.latency) })
+}
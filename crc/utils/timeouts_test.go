@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutsFromConfig(t *testing.T) {
+	timeouts, err := timeoutsFromConfig(crcConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, 60*time.Second, timeouts.timeout(ServiceAggregator, 60*time.Second))
+	assert.Equal(t, DefaultTimeout, timeouts.timeout(ServiceOCPVulnerability, DefaultTimeout))
+
+	timeouts, err = timeoutsFromConfig(parseConfig(t, `
+timeout  = "30s"
+timeouts = { "ocp-vulnerability" = "2m" }
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeouts.timeout(ServiceAggregator, 60*time.Second))
+	assert.Equal(t, 2*time.Minute, timeouts.timeout(ServiceOCPVulnerability, DefaultTimeout))
+	assert.Equal(t, 30*time.Second, timeouts.timeout("", DefaultTimeout))
+
+	for _, config := range []string{`timeout = "0s"`, `timeout = "soon"`, `timeouts = { "aggregator" = "-1m" }`} {
+		_, err := timeoutsFromConfig(parseConfig(t, config))
+		assert.ErrorContains(t, err, "must be a positive duration", config)
+	}
+}
+
+func TestAdaptiveTimeouts(t *testing.T) {
+	timeouts, err := timeoutsFromConfig(parseConfig(t, `adaptive_timeout = true`))
+	assert.NoError(t, err)
+
+	// fast requests keep the configured timeout
+	timeouts.observe(ServiceAggregator, time.Second)
+	assert.Equal(t, DefaultTimeout, timeouts.timeout(ServiceAggregator, DefaultTimeout))
+
+	// slow requests raise it to a multiple of the average latency
+	timeouts.observe(ServiceOCPVulnerability, 10*time.Second)
+	assert.Equal(t, 40*time.Second, timeouts.timeout(ServiceOCPVulnerability, DefaultTimeout))
+	timeouts.observe(ServiceOCPVulnerability, 20*time.Second)
+	assert.Equal(t, 48*time.Second, timeouts.timeout(ServiceOCPVulnerability, DefaultTimeout))
+
+	// up to MaxAdaptiveTimeout
+	timeouts.observe(ServiceGathering, time.Hour)
+	assert.Equal(t, MaxAdaptiveTimeout, timeouts.timeout(ServiceGathering, DefaultTimeout))
+
+	timeouts, err = timeoutsFromConfig(crcConfig{})
+	assert.NoError(t, err)
+	timeouts.observe(ServiceOCPVulnerability, time.Minute)
+	assert.Equal(t, DefaultTimeout, timeouts.timeout(ServiceOCPVulnerability, DefaultTimeout))
+}
+
+func TestTimedBody(t *testing.T) {
+	var observed []time.Duration
+	body := &timedBody{
+		ReadCloser: io.NopCloser(strings.NewReader("0123456789")),
+		latency:    time.Second,
+		onDone:     func(latency time.Duration) { observed = append(observed, latency) },
+	}
+
+	// the time spent between the reads isn't observed
+	buf := make([]byte, 4)
+	for {
+		_, err := body.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NoError(t, body.Close())
+	if assert.Len(t, observed, 1) {
+		assert.GreaterOrEqual(t, observed[0], time.Second)
+		assert.Less(t, observed[0], time.Second+20*time.Millisecond)
+	}
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the name of the tracer instrumenting the plugin
+const TracerName = "github.com/juandspy/steampipe-plugin-crc"
+
+// The attributes of the spans
+const (
+	TableAttribute       = attribute.Key("crc.table")
+	RowsAttribute        = attribute.Key("crc.rows")
+	MethodAttribute      = attribute.Key("http.request.method")
+	URLTemplateAttribute = attribute.Key("url.template")
+	StatusCodeAttribute  = attribute.Key("http.response.status_code")
+	GrantTypeAttribute   = attribute.Key("crc.grant_type")
+)
+
+var (
+	// tracerProviderOverride replaces the tracer provider of every connection, see SetTracerProvider
+	tracerProviderOverride trace.TracerProvider
+	// tracerProviderGeneration changes with tracerProviderOverride, so that
+	// the connections don't keep the tracers of the previous provider
+	tracerProviderGeneration atomic.Int64
+	// tracerProviders are the tracer providers exporting to each endpoint
+	tracerProviders   = map[string]trace.TracerProvider{}
+	tracerProvidersMu sync.Mutex
+)
+
+// SetTracerProvider makes every connection record its spans with the given
+// tracer provider, e.g. one exporting them in memory in the tests, whatever
+// their configuration. Passing nil restores the configured tracer providers.
+func SetTracerProvider(provider trace.TracerProvider) {
+	tracerProvidersMu.Lock()
+	defer tracerProvidersMu.Unlock()
+	tracerProviderOverride = provider
+	tracerProviderGeneration.Add(1)
+}
+
+// tracerProviderFromConfig returns the tracer provider of the connection,
+// which exports its spans with OTLP to 'tracing_endpoint' or drops them if it
+// is not set. The connections exporting to the same endpoint share their
+// tracer provider.
+func tracerProviderFromConfig(config crcConfig) (trace.TracerProvider, error) {
+	tracerProvidersMu.Lock()
+	defer tracerProvidersMu.Unlock()
+
+	if tracerProviderOverride != nil {
+		return tracerProviderOverride, nil
+	}
+	if config.TracingEndpoint == nil || *config.TracingEndpoint == "" {
+		return noop.NewTracerProvider(), nil
+	}
+
+	if err := validateTracingEndpoint(config); err != nil {
+		return nil, err
+	}
+	endpoint := *config.TracingEndpoint
+	insecure := config.TracingInsecure != nil && *config.TracingInsecure
+
+	key := fmt.Sprintf("%s insecure=%t", endpoint, insecure)
+	if provider, ok := tracerProviders[key]; ok {
+		return provider, nil
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	// the exporter connects lazily, so a collector being down doesn't fail the queries
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the exporter of 'tracing_endpoint': %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "steampipe-plugin-crc"))),
+	)
+	tracerProviders[key] = provider
+	return provider, nil
+}
+
+// tracer returns the tracer of the connection of the query, resolved once
+// per connection and tracing settings
+func tracer(d *plugin.QueryData) trace.Tracer {
+	config := GetConfig(d.Connection)
+	if d.ConnectionManager == nil {
+		return tracerOrNoop(tracerProviderFromConfig(config))
+	}
+
+	var endpoint string
+	if config.TracingEndpoint != nil {
+		endpoint = *config.TracingEndpoint
+	}
+	insecure := config.TracingInsecure != nil && *config.TracingInsecure
+	cacheKey := fmt.Sprintf("crc_tracer_%d_%s_%t", tracerProviderGeneration.Load(), endpoint, insecure)
+	if cachedData, ok := d.ConnectionManager.Cache.Get(cacheKey); ok {
+		return cachedData.(trace.Tracer)
+	}
+	tracer := tracerOrNoop(tracerProviderFromConfig(config))
+	d.ConnectionManager.Cache.Set(cacheKey, tracer)
+	return tracer
+}
+
+// tracerOrNoop returns the tracer of the provider, or one dropping the spans
+// if the provider is invalid. The configuration errors are reported when the
+// client of the connection is created.
+func tracerOrNoop(provider trace.TracerProvider, err error) trace.Tracer {
+	if err != nil || provider == nil {
+		return noop.NewTracerProvider().Tracer(TracerName)
+	}
+	return provider.Tracer(TracerName)
+}
+
+// tableName returns the name of the table queried, if any
+func tableName(d *plugin.QueryData) string {
+	if d.Table == nil {
+		return ""
+	}
+	return d.Table.Name
+}
+
+// HydrateSpan is the span of a hydrate function, counting the rows it streams
+type HydrateSpan struct {
+	trace.Span
+	rows atomic.Int64
+}
+
+// hydrateSpanKey is the context key of the span of the running hydrate function
+type hydrateSpanKey struct{}
+
+// StartHydrateSpan starts the span of the hydrate function, as a child of the
+// span of the Steampipe query, if any. Every hydrate function starts one and
+// ends it once done:
+//
+//	ctx, span := utils.StartHydrateSpan(ctx, d, "listClustersV2")
+//	defer span.End()
+func StartHydrateSpan(ctx context.Context, d *plugin.QueryData, hydrate string) (context.Context, *HydrateSpan) {
+	ctx, span := tracer(d).Start(ctx, hydrate, trace.WithAttributes(TableAttribute.String(tableName(d))))
+	hydrateSpan := &HydrateSpan{Span: span}
+	return context.WithValue(ctx, hydrateSpanKey{}, hydrateSpan), hydrateSpan
+}
+
+// AddRows counts rows returned by the hydrate function
+func (s *HydrateSpan) AddRows(rows int64) {
+	s.rows.Add(rows)
+}
+
+// End ends the span with the number of rows returned by the hydrate function
+func (s *HydrateSpan) End(options ...trace.SpanEndOption) {
+	s.SetAttributes(RowsAttribute.Int64(s.rows.Load()))
+	s.Span.End(options...)
+}
+
+// StreamListItem streams the item, counting it in the rows of the hydrate span
+func StreamListItem(ctx context.Context, d *plugin.QueryData, item interface{}) {
+	unlock := lockStream(ctx)
+	d.StreamListItem(ctx, item)
+	unlock()
+	if span, ok := ctx.Value(hydrateSpanKey{}).(*HydrateSpan); ok {
+		span.AddRows(1)
+	}
+}
+
+// recordError marks the span of the context as failed
+func recordError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// endSpan ends the span of an API or token request with its status code
+func endSpan(span trace.Span, statusCode int, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		statusCode = apiErr.StatusCode
+	}
+	if statusCode != 0 {
+		span.SetAttributes(StatusCodeAttribute.Int(statusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endpointParameters match the path segments identifying resources, e.g.
+// clusters, CVEs or OCP versions, and the placeholders replacing them
+var endpointParameters = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), "{id}"},
+	{regexp.MustCompile(`^(?i)CVE-\d{4}-\d+$`), "{cve}"},
+	{regexp.MustCompile(`^v?\d+\.\d+(\.\d+)?([.+-].*)?$`), "{version}"},
+	{regexp.MustCompile(`^\d+$`), "{id}"},
+}
+
+// endpointTemplateKey is the context key of withEndpointTemplate
+type endpointTemplateKey struct{}
+
+// withEndpointTemplate returns a context whose API requests are traced with
+// the template of their endpoint, e.g. "api/ocp-vulnerability/v1/clusters/{cluster_id}/cves"
+func withEndpointTemplate(ctx context.Context, template string) context.Context {
+	return context.WithValue(ctx, endpointTemplateKey{}, template)
+}
+
+// requestTemplate returns the template of the endpoint requested, without its
+// query, so that the spans of the requests to the same API endpoint are
+// grouped together. It is the template of the context, if any, see
+// withEndpointTemplate, or the one guessed from the endpoint itself, e.g. for
+// the crc_api_request table.
+func requestTemplate(ctx context.Context, endpoint string) string {
+	if template, ok := ctx.Value(endpointTemplateKey{}).(string); ok {
+		template, _, _ = strings.Cut(template, "?")
+		return template
+	}
+	return endpointTemplate(endpoint)
+}
+
+// endpointTemplate returns the endpoint without its query and with the
+// resource identifiers replaced by placeholders
+func endpointTemplate(endpoint string) string {
+	path, _, _ := strings.Cut(endpoint, "?")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		for _, parameter := range endpointParameters {
+			if parameter.pattern.MatchString(segment) {
+				segments[i] = parameter.placeholder
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndpointTemplate(t *testing.T) {
+	tests := map[string]string{
+		"api/insights-results-aggregator/v2/clusters":                                             "api/insights-results-aggregator/v2/clusters",
+		"api/insights-results-aggregator/v2/cluster/0b3f7d1c-2a5e-4c8f-9d6b-1e2f3a4b5c6d/reports": "api/insights-results-aggregator/v2/cluster/{id}/reports",
+		"api/ocp-vulnerability/v1/cves/CVE-2023-44487/exposed_clusters?limit=100&offset=0":        "api/ocp-vulnerability/v1/cves/{cve}/exposed_clusters",
+		"api/gathering/v2/4.14.0/gathering_rules":                                                 "api/gathering/v2/{version}/gathering_rules",
+		"api/gathering/v2/4.14.0-rc.1/gathering_rules":                                            "api/gathering/v2/{version}/gathering_rules",
+		"api/things/42": "api/things/{id}",
+	}
+	for endpoint, expected := range tests {
+		assert.Equal(t, expected, requestTemplate(context.Background(), endpoint), endpoint)
+	}
+
+	// the template of the table is used as is, whatever its parameters look like
+	ctx := withEndpointTemplate(context.Background(), "api/gathering/v2/{ocp_version}/gathering_rules")
+	assert.Equal(t, "api/gathering/v2/{ocp_version}/gathering_rules", requestTemplate(ctx, "api/gathering/v2/latest/gathering_rules?a=1"))
+}
+
+func TestTracerProviderFromConfig(t *testing.T) {
+	provider, err := tracerProviderFromConfig(crcConfig{})
+	assert.NoError(t, err)
+	_, span := provider.Tracer(TracerName).Start(context.Background(), "span")
+	assert.False(t, span.IsRecording(), "the spans are only exported if 'tracing_endpoint' is set")
+
+	endpoint, insecure := "localhost:4317", true
+	provider, err = tracerProviderFromConfig(crcConfig{TracingEndpoint: &endpoint, TracingInsecure: &insecure})
+	assert.NoError(t, err)
+	_, span = provider.Tracer(TracerName).Start(context.Background(), "span")
+	assert.True(t, span.IsRecording())
+	shared, err := tracerProviderFromConfig(crcConfig{TracingEndpoint: &endpoint, TracingInsecure: &insecure})
+	assert.NoError(t, err)
+	assert.Same(t, provider, shared)
+
+	invalid := "http://localhost"
+	_, err = tracerProviderFromConfig(crcConfig{TracingEndpoint: &invalid})
+	assert.ErrorContains(t, err, "'tracing_endpoint'")
+}
+
+func TestAuthenticateSpan(t *testing.T) {
+	var issued int32
+	tokenServer := newTokenServer(t, &issued, 0)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	client := NewSSOClient("id", "secret", tokenServer.URL)
+	client.tracer = provider.Tracer(TracerName)
+	_, err := client.accessToken(context.Background())
+	assert.NoError(t, err)
+
+	client = NewSSOClient("id", "secret", "http://127.0.0.1:0")
+	client.tracer = provider.Tracer(TracerName)
+	_, err = client.accessToken(context.Background())
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "SSOClient.authenticate", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, GrantTypeAttribute.String("client_credentials"))
+		assert.Contains(t, spans[0].Attributes, attribute.Int(string(StatusCodeAttribute), 200))
+		assert.Equal(t, codes.Unset, spans[0].Status.Code)
+		assert.Equal(t, codes.Error, spans[1].Status.Code)
+	}
+}
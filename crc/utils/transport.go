@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// newTransport builds the transport used both for the API and the token
+// requests from the proxy and TLS settings of the connection
+func newTransport(config crcConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.ProxyURL != nil && *config.ProxyURL != "" {
+		proxyURL, err := parseURL("proxy_url", *config.ProxyURL, "http", "https", "socks5")
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// newTLSConfig builds the TLS configuration from the CA bundle, client
+// certificate and verification settings of the connection
+func newTLSConfig(config crcConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CABundlePath != nil && *config.CABundlePath != "" {
+		pem, err := os.ReadFile(*config.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading 'ca_bundle_path': %v", err)
+		}
+
+		// trust the bundle on top of the system certificates
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("'ca_bundle_path' %s doesn't contain any PEM certificate", *config.CABundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certPath, keyPath := "", ""
+	if config.ClientCertPath != nil {
+		certPath = *config.ClientCertPath
+	}
+	if config.ClientKeyPath != nil {
+		keyPath = *config.ClientKeyPath
+	}
+	switch {
+	case certPath != "" && keyPath != "":
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case certPath != "":
+		return nil, errors.New("'client_key_path' must be set along with 'client_cert_path'")
+	case keyPath != "":
+		return nil, errors.New("'client_cert_path' must be set along with 'client_key_path'")
+	}
+
+	if config.InsecureSkipVerify != nil && *config.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
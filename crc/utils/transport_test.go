@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePEM writes a PEM block in a temporary directory and returns its path
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+// writeClientCertificate generates a self-signed client certificate and returns the paths of the certificate and key
+func writeClientCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "steampipe-plugin-crc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return writePEM(t, "client.crt", "CERTIFICATE", cert), writePEM(t, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestTransportCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	transport, err := newTransport(crcConfig{})
+	assert.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	assert.ErrorContains(t, err, "certificate")
+
+	bundle := writePEM(t, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	transport, err = newTransport(crcConfig{CABundlePath: &bundle})
+	assert.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	insecure := true
+	transport, err = newTransport(crcConfig{InsecureSkipVerify: &insecure})
+	assert.NoError(t, err)
+	resp, err = (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Len(t, r.TLS.PeerCertificates, 1)
+		assert.Equal(t, "steampipe-plugin-crc", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	bundle := writePEM(t, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	certPath, keyPath := writeClientCertificate(t)
+	transport, err := newTransport(crcConfig{CABundlePath: &bundle, ClientCertPath: &certPath, ClientKeyPath: &keyPath})
+	assert.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	_, err = newTransport(crcConfig{ClientCertPath: &certPath})
+	assert.ErrorContains(t, err, "client_key_path")
+}
+
+func TestTransportProxyForAPIAndTokenRequests(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		mu.Unlock()
+		if r.URL.Path == "/token" {
+			fmt.Fprint(w, `{"access_token": "token", "expires_in": 300}`)
+		}
+	}))
+	defer proxy.Close()
+
+	transport, err := newTransport(crcConfig{ProxyURL: &proxy.URL})
+	assert.NoError(t, err)
+
+	ssoClient := NewSSOClient("id", "secret", "http://sso.example.com/token")
+	ssoClient.Transport = transport
+	resp, err := newAuthenticatedClient(ssoClient, DefaultTimeout).Get("http://console.example.com/api/clusters")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, []string{
+		"POST http://sso.example.com/token",
+		"GET http://console.example.com/api/clusters",
+	}, proxied)
+
+	invalid := "ftp://proxy.example.com"
+	_, err = newTransport(crcConfig{ProxyURL: &invalid})
+	assert.ErrorContains(t, err, "proxy_url")
+}